@@ -26,6 +26,8 @@ go get -u github.com/hashicorp/terraform/terraform
 go get -u github.com/hashicorp/terraform/communicator
 go get -u github.com/hashicorp/terraform/config
 go get -u github.com/mitchellh/go-linereader
+go get -u github.com/pelletier/go-toml
+go get -u golang.org/x/crypto/ssh
 go get -u github.com/chef-partners/terraform-provisioner-habitat/habitat
 
 echo "Installed project and dependencies"
@@ -0,0 +1,236 @@
+package habitat
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testApply runs applyFn for config against a fake communicator and returns
+// its transcript.
+func testApply(t *testing.T, connType string, config map[string]interface{}) string {
+	// Probes for the state of a fresh target fail
+	comm := &fakeCommunicator{
+		failures: map[string]int{
+			"hab pkg path":   100,
+			"hab sup status": 100,
+			"busybox id hab": 100,
+			"sha256sum":      100,
+			"Get-FileHash":   100,
+		},
+		responses: map[string]string{
+			"%{http_code}":      "[]\n200\n",
+			"Invoke-WebRequest": "[]\n200\n",
+		},
+	}
+	if connType == "winrm" {
+		comm.scriptPath = "C:/Windows/Temp/terraform_1.cmd"
+	}
+
+	originalNow := now
+	now = func() time.Time { return time.Unix(1546300800, 0) }
+	defer func() { now = originalNow }()
+
+	original := newCommunicator
+	newCommunicator = func(*terraform.InstanceState) (communicator.Communicator, error) {
+		return comm, nil
+	}
+	defer func() { newCommunicator = original }()
+
+	state := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{ConnInfo: map[string]string{"type": connType}},
+	}
+	ctx := context.WithValue(context.Background(), schema.ProvOutputKey, new(terraform.MockUIOutput))
+	ctx = context.WithValue(ctx, schema.ProvRawStateKey, state)
+	ctx = context.WithValue(ctx, schema.ProvConfigDataKey, schema.TestResourceDataRaw(t, Provisioner().Schema, config))
+
+	if err := applyFn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return comm.transcript.String()
+}
+
+func TestApply_golden(t *testing.T) {
+	cases := map[string]struct {
+		connType string
+		config   map[string]interface{}
+	}{
+		"linux_systemd": {
+			connType: "ssh",
+			config: map[string]interface{}{
+				"accept_license":     true,
+				"version":            "0.79.1",
+				"peer":               "10.0.0.1",
+				"ring_key":           "test-ring",
+				"ring_key_content":   "SYM-SEC-1\ntest-ring-20190101000000\n\nc2VjcmV0",
+				"builder_auth_token": "s3cret",
+				"service": []interface{}{
+					map[string]interface{}{
+						"name":        "core/redis",
+						"topology":    "leader",
+						"user_toml":   "port = 6380\n",
+						"service_key": "BOX-SEC-1\nredis.default@org-20190101000000\n\nc2VjcmV0",
+					},
+					map[string]interface{}{
+						"name":     "core/nginx",
+						"binds":    []interface{}{"backend:redis.default"},
+						"strategy": "rolling",
+					},
+				},
+			},
+		},
+		"linux_unmanaged_nosudo": {
+			connType: "ssh",
+			config: map[string]interface{}{
+				"accept_license": true,
+				"use_sudo":       false,
+				"service_type":   "unmanaged",
+				"permanent_peer": true,
+				"service": []interface{}{
+					map[string]interface{}{
+						"name":  "core/nginx",
+						"group": "prod",
+						"bind": []interface{}{
+							map[string]interface{}{"alias": "backend", "service": "redis", "group": "prod"},
+						},
+					},
+				},
+			},
+		},
+		"linux_apply_config": {
+			connType: "ssh",
+			config: map[string]interface{}{
+				"accept_license": true,
+				"organization":   "acme",
+				"service": []interface{}{
+					map[string]interface{}{
+						"name":         "core/redis",
+						"group":        "prod",
+						"config":       map[string]interface{}{"port": "6380", "save.seconds": "60"},
+						"config_types": map[string]interface{}{"port": "integer", "save.seconds": "integer"},
+						"apply_config": "hash",
+					},
+				},
+			},
+		},
+		"linux_destroy": {
+			connType: "ssh",
+			config: map[string]interface{}{
+				"accept_license": true,
+				"destroy":        true,
+				"remove_hab":     true,
+				"service": []interface{}{
+					map[string]interface{}{"name": "core/redis"},
+				},
+			},
+		},
+		"windows": {
+			connType: "winrm",
+			config: map[string]interface{}{
+				"accept_license":   true,
+				"version":          "0.90.6",
+				"listen_ctl":       "127.0.0.1:9642",
+				"ring_key":         "test-ring",
+				"ring_key_content": "SYM-SEC-1\ntest-ring-20190101000000\n\nc2VjcmV0",
+				"service": []interface{}{
+					map[string]interface{}{
+						"name":        "core/redis",
+						"binds":       []interface{}{"backend:nginx.default"},
+						"user_toml":   "port = 6380\n",
+						"service_key": "BOX-SEC-1\nredis.default@org-20190101000000\n\nc2VjcmV0",
+					},
+				},
+			},
+		},
+		"windows_destroy": {
+			connType: "winrm",
+			config: map[string]interface{}{
+				"accept_license": true,
+				"destroy":        true,
+				"listen_ctl":     "127.0.0.1:9642",
+				"service": []interface{}{
+					map[string]interface{}{"name": "core/redis"},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assertGolden(t, name, testApply(t, tc.connType, tc.config))
+		})
+	}
+}
+
+func TestApply_render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "habitat-render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, connType := range []string{"ssh", "winrm"} {
+		script := filepath.Join(dir, connType)
+		config := map[string]interface{}{
+			"accept_license":     true,
+			"version":            "0.90.6",
+			"render_only":        script,
+			"builder_auth_token": "s3cret",
+			"install_checksum":   strings.Repeat("ab", 32),
+			"service": []interface{}{
+				map[string]interface{}{
+					"name":            "core/redis",
+					"binds":           []interface{}{"backend:nginx.default"},
+					"user_toml":       "port = 6380\n",
+					"service_key":     "BOX-SEC-1\nredis.default@org-20190101000000\n\nc2VjcmV0",
+					"wait_for_health": true,
+				},
+			},
+		}
+
+		// Nothing runs on the target when rendering
+		if transcript := testApply(t, connType, config); transcript != "" {
+			t.Errorf("%s: expected no commands, got:\n%s", connType, transcript)
+		}
+
+		b, err := ioutil.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "s3cret") || strings.Contains(string(b), "c2VjcmV0") {
+			t.Errorf("%s: the rendered script contains secrets:\n%s", connType, b)
+		}
+		assertGolden(t, "render_"+connType, string(b))
+	}
+}
+
+// assertGolden compares got with the golden file testdata/<name>.golden, or
+// updates it if the -update flag is set.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(expected) {
+		t.Errorf("output differs from %s, run go test -update to accept it:\n%s", golden, got)
+	}
+}
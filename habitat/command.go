@@ -0,0 +1,276 @@
+package habitat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// safeArg matches arguments that need no quoting in any of the shells we
+// render commands for.
+var safeArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// command is a remote command assembled from individual arguments. The
+// arguments and environment values are only quoted for the target shell when
+// the command is rendered, so a value taken from the configuration can never
+// break out of the argument it was passed as.
+type command struct {
+	args      []string
+	env       [][2]string
+	secretEnv [][2]string
+	sudo      bool
+	input     io.Reader
+}
+
+// newCommand returns a command running the given program and arguments.
+func newCommand(args ...string) *command {
+	return &command{args: args}
+}
+
+// Args appends arguments to the command.
+func (c *command) Args(args ...string) *command {
+	c.args = append(c.args, args...)
+	return c
+}
+
+// Option appends a flag followed by its value, unless the value is empty.
+func (c *command) Option(flag, value string) *command {
+	if value != "" {
+		c.args = append(c.args, flag, value)
+	}
+	return c
+}
+
+// Flag appends a flag without a value if set is true.
+func (c *command) Flag(flag string, set bool) *command {
+	if set {
+		c.args = append(c.args, flag)
+	}
+	return c
+}
+
+// Env sets an environment variable for the command, unless the value is empty.
+func (c *command) Env(name, value string) *command {
+	if value != "" {
+		c.env = append(c.env, [2]string{name, value})
+	}
+	return c
+}
+
+// SecretEnv sets an environment variable for the command, unless the value is
+// empty. Unlike Env, the value is never part of the rendered command line. On
+// POSIX targets it is passed on stdin, on Windows targets the command has to
+// be run from an uploaded script.
+func (c *command) SecretEnv(name, value string) *command {
+	if value != "" {
+		c.secretEnv = append(c.secretEnv, [2]string{name, value})
+	}
+	return c
+}
+
+// HasSecrets reports whether the command has secret environment variables.
+func (c *command) HasSecrets() bool {
+	return len(c.secretEnv) > 0
+}
+
+// Sudo runs the command through sudo if sudo is true.
+func (c *command) Sudo(sudo bool) *command {
+	c.sudo = sudo
+	return c
+}
+
+// Input feeds r to the standard input of the command.
+func (c *command) Input(r io.Reader) *command {
+	c.input = r
+	return c
+}
+
+// String renders the command for a POSIX shell.
+func (c *command) String() string {
+	var parts []string
+	if c.sudo {
+		parts = append(parts, "sudo")
+	}
+	if len(c.env) > 0 {
+		parts = append(parts, "env")
+		for _, e := range c.env {
+			parts = append(parts, shellQuote(e[0]+"="+e[1]))
+		}
+	}
+	if len(c.secretEnv) > 0 {
+		// Read the secrets from stdin before running the actual command
+		var script []string
+		var names []string
+		for _, e := range c.secretEnv {
+			script = append(script, fmt.Sprintf("IFS= read -r %s", e[0]))
+			names = append(names, e[0])
+		}
+		script = append(script, "export "+strings.Join(names, " "))
+		if c.input == nil {
+			script = append(script, `exec "$@" < /dev/null`)
+		} else {
+			script = append(script, `exec "$@"`)
+		}
+		parts = append(parts, "sh", "-c", shellQuote(strings.Join(script, " && ")), "sh")
+	}
+	for _, arg := range c.args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Stdin returns the standard input of the command as rendered by String.
+func (c *command) Stdin() io.Reader {
+	if len(c.secretEnv) == 0 {
+		return c.input
+	}
+
+	var values []string
+	for _, e := range c.secretEnv {
+		values = append(values, e[1]+"\n")
+	}
+	secrets := strings.NewReader(strings.Join(values, ""))
+	if c.input == nil {
+		return secrets
+	}
+	return io.MultiReader(secrets, c.input)
+}
+
+// PowerShell renders the command as a PowerShell script. The script stops on
+// the first error and exits with the exit code of the command.
+func (c *command) PowerShell() string {
+	var b strings.Builder
+	b.WriteString("$ErrorActionPreference = 'Stop'\n")
+	for _, env := range [][][2]string{c.env, c.secretEnv} {
+		for _, e := range env {
+			fmt.Fprintf(&b, "$env:%s = %s\n", e[0], psQuote(e[1]))
+		}
+	}
+	b.WriteString("&")
+	for _, arg := range c.args {
+		b.WriteString(" " + psArg(arg))
+	}
+	b.WriteString("\nexit $LASTEXITCODE\n")
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if safeArg.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// psQuote quotes s as a PowerShell string literal.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// psArg quotes s as an argument to a PowerShell command. Windows PowerShell
+// does not escape embedded double quotes when it builds the command line of a
+// native program, so they are escaped here.
+func psArg(s string) string {
+	if safeArg.MatchString(s) {
+		return s
+	}
+	return psQuote(strings.Replace(s, `"`, `\"`, -1))
+}
+
+// windowsArg quotes s following the rules used by CommandLineToArgvW, for
+// command lines that are parsed by a Windows program rather than a shell.
+func windowsArg(s string) string {
+	if safeArg.MatchString(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// systemdQuote quotes s for use in a systemd unit file setting.
+func systemdQuote(s string) string {
+	s = strings.Replace(s, "%", "%%", -1)
+	if safeArg.MatchString(s) {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$", "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// envFileQuote quotes s as a value in a file read with EnvironmentFile=.
+// systemd doesn't expand specifiers in these files, and only unescapes \, ",
+// $ and ` in double quoted values.
+func envFileQuote(s string) string {
+	if safeArg.MatchString(s) {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(s) + `"`
+}
+
+// powerShellCommand returns a command line that runs script with PowerShell.
+// The script is passed base64 encoded, so it is not subject to any quoting
+// by the shell that starts PowerShell.
+func powerShellCommand(script string) string {
+	var raw []byte
+	for _, u := range utf16.Encode([]rune(script)) {
+		raw = append(raw, byte(u), byte(u>>8))
+	}
+	return "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand " +
+		base64.StdEncoding.EncodeToString(raw)
+}
+
+// decodePowerShell splits a command created by powerShellCommand into the
+// command line and the decoded script, for instance to show it.
+func decodePowerShell(command string) (string, string, bool) {
+	const flag = " -EncodedCommand "
+	i := strings.Index(command, flag)
+	if i < 0 {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(command[i+len(flag):])
+	if err != nil || len(raw)%2 != 0 {
+		return "", "", false
+	}
+	script := make([]uint16, len(raw)/2)
+	for j := range script {
+		script[j] = uint16(raw[2*j]) | uint16(raw[2*j+1])<<8
+	}
+	return command[:i+len(flag)-1], string(utf16.Decode(script)), true
+}
+
+// powerShellFile returns a command line that runs the script file at path
+// with PowerShell.
+func powerShellFile(path string) string {
+	return "powershell -NoProfile -ExecutionPolicy Bypass -File " + windowsArg(path)
+}
+
+// joinArgs renders args as a single command line using quote.
+func joinArgs(args []string, quote func(string) string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,240 @@
+package habitat
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+var hostileInputs = []string{
+	"",
+	"plain",
+	"with space",
+	"it's",
+	`"double"`,
+	"$(touch /tmp/pwned)",
+	"`id`",
+	"a;b && c || d | e",
+	"back\\slash\\",
+	"new\nline",
+	"*?[glob]",
+	"~root",
+	"-e --flag",
+	"%i %%",
+	"[section]\nkey = \"value\"\nother = 'it''s'\n",
+}
+
+// testShell runs command with sh and a stub hab binary that prints its
+// arguments, preceded by the value of HAB_AUTH_TOKEN, separated by NUL bytes.
+func testShell(t *testing.T, command string, stdin io.Reader) []string {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "habitat-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stub := "#!/bin/sh\nprintf '%s\\0' \"$HAB_AUTH_TOKEN\" \"$@\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "hab"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(sh, "-c", command)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	cmd.Env = []string{"PATH=" + dir + string(os.PathListSeparator) + os.Getenv("PATH")}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("error running %q: %s", command, err)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+func TestShellQuote_roundTrip(t *testing.T) {
+	for _, input := range hostileInputs {
+		args := testShell(t, "hab "+shellQuote(input), nil)
+		if len(args) != 2 || args[1] != input {
+			t.Errorf("shellQuote(%q) = %q, shell saw %q", input, shellQuote(input), args[1:])
+		}
+	}
+}
+
+func TestCommand_hostileService(t *testing.T) {
+	for _, input := range hostileInputs {
+		service := Service{
+			Name:     "core/" + input,
+			Topology: input,
+			Group:    input,
+			Binds:    []Bind{{Alias: input, Service: input, Group: input}},
+		}
+		cmd := newCommand("hab", "svc", "load", service.Name).
+			Args(service.loadOptions()...).
+			Env("HAB_AUTH_TOKEN", input)
+
+		expected := append([]string{input, "svc", "load", service.Name}, service.loadOptions()...)
+		if got := testShell(t, cmd.String(), nil); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %q, got %q", cmd.String(), expected, got)
+		}
+	}
+}
+
+func TestCommand_hostilePeer(t *testing.T) {
+	for _, input := range hostileInputs {
+		p := &provisioner{Peer: input, Events: input, PermanentPeer: true}
+		cmd := newCommand("hab", "sup", "run").Args(p.supOptions()...)
+
+		expected := append([]string{"", "sup", "run"}, p.supOptions()...)
+		if got := testShell(t, cmd.String(), nil); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %q, got %q", cmd.String(), expected, got)
+		}
+	}
+}
+
+func TestCommand_SecretEnv(t *testing.T) {
+	for _, input := range hostileInputs {
+		if strings.Contains(input, "\n") {
+			continue
+		}
+
+		cmd := newCommand("hab", "pkg", "install", "core/redis").
+			Env("HAB_NONINTERACTIVE", "true").
+			SecretEnv("HAB_AUTH_TOKEN", input)
+		if input != "" && strings.Contains(cmd.String(), input) {
+			t.Errorf("secret %q is part of the command line: %s", input, cmd.String())
+		}
+
+		expected := []string{input, "pkg", "install", "core/redis"}
+		if got := testShell(t, cmd.String(), cmd.Stdin()); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %q, got %q", cmd.String(), expected, got)
+		}
+	}
+}
+
+func TestCommand_String(t *testing.T) {
+	cases := []struct {
+		cmd      *command
+		expected string
+	}{
+		{
+			newCommand("hab", "svc", "load", "core/redis").Option("--group", "").Option("--topology", "leader"),
+			"hab svc load core/redis --topology leader",
+		},
+		{
+			newCommand("hab", "pkg", "install", "core/redis").Env("HAB_NONINTERACTIVE", "true").Env("HAB_AUTH_TOKEN", "").Sudo(true),
+			"sudo env HAB_NONINTERACTIVE=true hab pkg install core/redis",
+		},
+		{
+			newCommand("hab", "svc", "load", "core/redis; reboot").Flag("--force", true),
+			"hab svc load 'core/redis; reboot' --force",
+		},
+		{
+			newCommand("hab", "ring", "key", "import").Env("HAB_AUTH_TOKEN", "it's"),
+			`env 'HAB_AUTH_TOKEN=it'"'"'s' hab ring key import`,
+		},
+	}
+
+	for _, tc := range cases {
+		if got := tc.cmd.String(); got != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestCommand_PowerShell(t *testing.T) {
+	cmd := newCommand("hab", "svc", "load", "core/redis").
+		Option("--bind", "backend:it's.\"default\"").
+		Env("HAB_AUTH_TOKEN", "$(secret)'")
+
+	expected := "$ErrorActionPreference = 'Stop'\n" +
+		"$env:HAB_AUTH_TOKEN = '$(secret)'''\n" +
+		"& hab svc load core/redis --bind 'backend:it''s.\\\"default\\\"'\n" +
+		"exit $LASTEXITCODE\n"
+	if got := cmd.PowerShell(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestWindowsArg(t *testing.T) {
+	cases := map[string]string{
+		"core/redis":        "core/redis",
+		"":                  `""`,
+		"with space":        `"with space"`,
+		`say "hi"`:          `"say \"hi\""`,
+		`C:\Program Files\`: `"C:\Program Files\\"`,
+		`a\"b`:              `"a\\\"b"`,
+	}
+
+	for input, expected := range cases {
+		if got := windowsArg(input); got != expected {
+			t.Errorf("windowsArg(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	cases := map[string]string{
+		"--peer":               "--peer",
+		"10.0.0.1:9638":        "10.0.0.1:9638",
+		"50%":                  "50%%",
+		"HAB_AUTH_TOKEN=a b":   `"HAB_AUTH_TOKEN=a b"`,
+		`a"b\c`:                `"a\"b\\c"`,
+		"$HOME":                `"$$HOME"`,
+		"line\nExecStart=evil": `"line\nExecStart=evil"`,
+	}
+
+	for input, expected := range cases {
+		if got := systemdQuote(input); got != expected {
+			t.Errorf("systemdQuote(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestEnvFileQuote(t *testing.T) {
+	cases := map[string]string{
+		"s3cret":      "s3cret",
+		"50%":         "50%",
+		"$HOME":       `"\$HOME"`,
+		`a"b\c`:       `"a\"b\\c"`,
+		"a b`cmd`":    "\"a b\\`cmd\\`\"",
+		"multi\nline": "\"multi\nline\"",
+	}
+
+	for input, expected := range cases {
+		if got := envFileQuote(input); got != expected {
+			t.Errorf("envFileQuote(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestPowerShellCommand(t *testing.T) {
+	script := "Write-Output 'it''s \"quoted\"' | Out-Null\n"
+	command := powerShellCommand(script)
+
+	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
+	if !strings.HasPrefix(command, prefix) {
+		t.Fatalf("unexpected command: %s", command)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+	}
+	if got := string(utf16.Decode(units)); got != script {
+		t.Errorf("expected %q, got %q", script, got)
+	}
+}
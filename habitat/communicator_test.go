@@ -0,0 +1,137 @@
+package habitat
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// fakeCommunicator is an in-memory communicator recording the commands it
+// runs and the files uploaded to it in a transcript.
+//
+// A command containing a key of failures exits with status 1 as many times as
+// the value of the key. A command containing a key of responses writes its
+// value to standard output; if several keys match, the longest one wins.
+type fakeCommunicator struct {
+	failures   map[string]int
+	responses  map[string]string
+	scriptPath string
+
+	commands   []string
+	transcript strings.Builder
+}
+
+func (c *fakeCommunicator) Connect(terraform.UIOutput) error {
+	return nil
+}
+
+func (c *fakeCommunicator) Disconnect() error {
+	return nil
+}
+
+func (c *fakeCommunicator) Timeout() time.Duration {
+	return time.Minute
+}
+
+func (c *fakeCommunicator) ScriptPath() string {
+	if c.scriptPath == "" {
+		return "/tmp/terraform_1.sh"
+	}
+	return c.scriptPath
+}
+
+func (c *fakeCommunicator) Start(cmd *remote.Cmd) error {
+	command := cmd.Command
+	if prefix, script, ok := decodePowerShell(cmd.Command); ok {
+		command = prefix + " " + script
+		fmt.Fprintf(&c.transcript, "$ %s\n", prefix)
+		c.record(">", strings.Trim(script, "\n"))
+	} else {
+		fmt.Fprintf(&c.transcript, "$ %s\n", command)
+	}
+	c.commands = append(c.commands, command)
+	if cmd.Stdin != nil {
+		stdin, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		c.record("<", string(stdin))
+	}
+	cmd.Init()
+
+	status := 0
+	for s, n := range c.failures {
+		if n > 0 && strings.Contains(command, s) {
+			c.failures[s] = n - 1
+			status = 1
+		}
+	}
+	if out, ok := c.response(command); ok && status == 0 {
+		io.WriteString(cmd.Stdout, out)
+	}
+	cmd.SetExitStatus(status, nil)
+	return nil
+}
+
+func (c *fakeCommunicator) Upload(dst string, content io.Reader) error {
+	return c.upload("upload", dst, content)
+}
+
+func (c *fakeCommunicator) UploadScript(dst string, content io.Reader) error {
+	return c.upload("upload script", dst, content)
+}
+
+func (c *fakeCommunicator) UploadDir(dst, src string) error {
+	fmt.Fprintf(&c.transcript, "upload dir %s %s\n", src, dst)
+	return nil
+}
+
+func (c *fakeCommunicator) upload(op, dst string, content io.Reader) error {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&c.transcript, "%s %s\n", op, dst)
+	c.record("|", string(b))
+	return nil
+}
+
+// record adds the lines of content to the transcript, each with a prefix.
+func (c *fakeCommunicator) record(prefix, content string) {
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		fmt.Fprintf(&c.transcript, "  %s %s\n", prefix, strings.TrimRight(line, "\r"))
+	}
+}
+
+// response returns the output of the longest key of responses contained in
+// command.
+func (c *fakeCommunicator) response(command string) (string, bool) {
+	var keys []string
+	for s := range c.responses {
+		if strings.Contains(command, s) {
+			keys = append(keys, s)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	return c.responses[keys[0]], true
+}
+
+// count returns the number of commands run containing s.
+func (c *fakeCommunicator) count(s string) int {
+	n := 0
+	for _, cmd := range c.commands {
+		if strings.Contains(cmd, s) {
+			n++
+		}
+	}
+	return n
+}
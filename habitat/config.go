@@ -0,0 +1,35 @@
+package habitat
+
+import "time"
+
+// applyConfigModes are the values of apply_config. With timestamp the
+// configuration is applied on every run, with hash only when it changed since
+// it was last applied from the target.
+var applyConfigModes = map[string]bool{
+	"timestamp": true,
+	"hash":      true,
+}
+
+// now returns the current time. Tests replace it for stable incarnations.
+var now = time.Now
+
+// serviceGroup returns the service group the service runs in, like
+// redis.default or redis.default@org.
+func (s *Service) serviceGroup(org string) string {
+	group := s.Group
+	if group == "" {
+		group = "default"
+	}
+	sg := s.Ident.Name + "." + group
+	if org != "" {
+		sg += "@" + org
+	}
+	return sg
+}
+
+// configIncarnation returns the incarnation to apply a configuration with.
+// The supervisors ignore configurations with an incarnation that isn't greater
+// than the last one applied, so it is the current time.
+func configIncarnation() uint64 {
+	return uint64(now().Unix())
+}
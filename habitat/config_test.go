@@ -0,0 +1,111 @@
+package habitat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestService_serviceGroup(t *testing.T) {
+	service := Service{Ident: PackageIdent{Origin: "core", Name: "redis"}}
+	if sg := service.serviceGroup(""); sg != "redis.default" {
+		t.Errorf("unexpected service group %s", sg)
+	}
+	service.Group = "prod"
+	if sg := service.serviceGroup("acme"); sg != "redis.prod@acme" {
+		t.Errorf("unexpected service group %s", sg)
+	}
+}
+
+func TestConfigIncarnation(t *testing.T) {
+	original := now
+	defer func() { now = original }()
+
+	now = func() time.Time { return time.Unix(1546300800, 0) }
+	first := configIncarnation()
+	now = func() time.Time { return time.Unix(1546300801, 0) }
+	if second := configIncarnation(); first != 1546300800 || second <= first {
+		t.Errorf("expected increasing incarnations, got %d and %d", first, second)
+	}
+}
+
+func TestLinuxPlatform_ApplyHabConfig(t *testing.T) {
+	p := &linuxPlatform{&provisioner{UseSudo: true}}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, UserTOML: "port = 6380\n", ApplyConfig: "hash"}
+
+	// The configuration is written only readable by root and kept
+	comm := &fakeCommunicator{}
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err != nil {
+		t.Fatal(err)
+	}
+	if comm.count("umask 077") != 1 || comm.count("config apply redis.default") != 1 || comm.count("/tmp") != 0 || comm.count("rm -f") != 0 {
+		t.Errorf("unexpected commands %v", comm.commands)
+	}
+
+	// An unchanged configuration isn't applied again with hash
+	comm = &fakeCommunicator{responses: map[string]string{
+		"sha256sum": sha256Hex([]byte(service.UserTOML)) + "  /hab/svc/redis/config-apply.toml\n",
+	}}
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err != nil {
+		t.Fatal(err)
+	}
+	if n := comm.count("config apply"); n != 0 {
+		t.Errorf("expected the unchanged configuration to be skipped, got %v", comm.commands)
+	}
+
+	// ... but is with timestamp
+	service.ApplyConfig = "timestamp"
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err != nil {
+		t.Fatal(err)
+	}
+	if n := comm.count("config apply"); n != 1 {
+		t.Errorf("expected the configuration to be applied, got %v", comm.commands)
+	}
+
+	// A failed apply removes the configuration, so it is applied again
+	comm = &fakeCommunicator{failures: map[string]int{"config apply": 1}}
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := comm.count("rm -f /hab/svc/redis/config-apply.toml"); n != 1 {
+		t.Errorf("expected the configuration to be removed, got %v", comm.commands)
+	}
+}
+
+func TestWindowsPlatform_ApplyHabConfig(t *testing.T) {
+	p := &windowsPlatform{&provisioner{}}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, UserTOML: "port = 6380\n", ApplyConfig: "hash"}
+
+	// The configuration is restricted before it is moved out of the script
+	// directory
+	comm := &fakeCommunicator{scriptPath: "C:/Windows/Temp/terraform_1.cmd"}
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err != nil {
+		t.Fatal(err)
+	}
+	transcript := comm.transcript.String()
+	for _, expected := range []string{
+		"upload C:/Windows/Temp/config-apply.toml",
+		"$acl.SetAccessRuleProtection($true, $false)",
+		"Move-Item -Force -LiteralPath $src -Destination $dst",
+		"& hab config apply redis.default",
+	} {
+		if !strings.Contains(transcript, expected) {
+			t.Errorf("expected the transcript to contain %s, got:\n%s", expected, transcript)
+		}
+	}
+	if strings.Index(transcript, "Set-Acl") > strings.Index(transcript, "Move-Item") {
+		t.Errorf("expected the configuration to be restricted before it is moved, got:\n%s", transcript)
+	}
+
+	// A failed apply removes the configuration, so it is applied again
+	comm = &fakeCommunicator{scriptPath: "C:/Windows/Temp/terraform_1.cmd", failures: map[string]int{"config apply": 1}}
+	if err := p.ApplyHabConfig(context.Background(), new(terraform.MockUIOutput), comm, service, "redis.default"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := comm.count(`Remove-Item -LiteralPath 'C:\hab\svc\redis\config-apply.toml'`); n != 1 {
+		t.Errorf("expected the configuration to be removed, got %v", comm.commands)
+	}
+}
@@ -0,0 +1,32 @@
+package habitat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// diagnose shows the last lines of the log of each supervisor after a failed
+// provisioning step. Logs that can't be read, for example because Habitat was
+// never installed, are skipped.
+func (p *provisioner) diagnose(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, newPlatform func(*provisioner) Platform) {
+	if p.DiagnosticLines <= 0 {
+		return
+	}
+
+	for _, sup := range p.supervisors() {
+		log, err := newPlatform(sup).HabLog(ctx, o, comm, p.DiagnosticLines)
+		log = strings.TrimRight(log, "\r\n")
+		if err != nil || strings.TrimSpace(log) == "" {
+			continue
+		}
+
+		o.Output(fmt.Sprintf("Last %d lines of the log of the habitat supervisor %s:", p.DiagnosticLines, sup.supName()))
+		for _, line := range strings.Split(log, "\n") {
+			o.Output(strings.TrimRight(line, "\r"))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package habitat
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestProvisioner_diagnose(t *testing.T) {
+	p := &provisioner{
+		DiagnosticLines: 20,
+		Supervisors:     []Supervisor{{OverrideName: "ring-b"}},
+	}
+	var output []string
+	o := &terraform.MockUIOutput{OutputFn: func(line string) { output = append(output, line) }}
+
+	var names []string
+	p.diagnose(context.Background(), o, nil, func(sup *provisioner) Platform {
+		names = append(names, sup.supName())
+		return &readinessPlatform{}
+	})
+
+	if !reflect.DeepEqual(names, []string{"default", "ring-b"}) {
+		t.Errorf("expected the logs of both supervisors, got %q", names)
+	}
+	expected := []string{
+		"Last 20 lines of the log of the habitat supervisor default:",
+		"hab-sup(MR): Unable to bind to 0.0.0.0:9638",
+		"redis.default(O): Can't open the append-only file: Permission denied",
+		"nginx.default(O): started",
+	}
+	if len(output) != 8 || !reflect.DeepEqual(output[:4], expected) {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestProvisioner_diagnose_disabled(t *testing.T) {
+	p := &provisioner{}
+	o := &terraform.MockUIOutput{}
+
+	p.diagnose(context.Background(), o, nil, func(sup *provisioner) Platform {
+		t.Fatal("no platform expected when diagnostics are disabled")
+		return nil
+	})
+	if o.OutputCalled {
+		t.Errorf("unexpected output: %q", o.OutputMessage)
+	}
+}
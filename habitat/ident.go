@@ -0,0 +1,85 @@
+package habitat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	identPartPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	versionPattern   = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+	releasePattern   = regexp.MustCompile(`^[0-9]{14}$`)
+)
+
+// PackageIdent identifies a habitat package as origin/name[/version[/release]].
+type PackageIdent struct {
+	Origin  string
+	Name    string
+	Version string
+	Release string
+}
+
+// parsePackageIdent parses and validates a package identifier like core/redis
+// or core/redis/4.0.10/20180801003001.
+func parsePackageIdent(ident string) (PackageIdent, error) {
+	parts := strings.Split(ident, "/")
+	if len(parts) < 2 || len(parts) > 4 {
+		return PackageIdent{}, fmt.Errorf("%s is not a valid package identifier, expected origin/name[/version[/release]].", ident)
+	}
+	n := len(parts)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	id := PackageIdent{Origin: parts[0], Name: parts[1], Version: parts[2], Release: parts[3]}
+
+	invalid := func(format string, args ...interface{}) (PackageIdent, error) {
+		return PackageIdent{}, fmt.Errorf("%s is not a valid package identifier: %s.", ident, fmt.Sprintf(format, args...))
+	}
+	if !identPartPattern.MatchString(id.Origin) {
+		return invalid("origin %q must only contain letters, digits, _ and -", id.Origin)
+	}
+	if !identPartPattern.MatchString(id.Name) {
+		return invalid("name %q must only contain letters, digits, _ and -", id.Name)
+	}
+	if id.Version == "" && id.Release != "" {
+		return invalid("a release requires a version")
+	}
+	if id.Version == "" && n > 2 {
+		return invalid("version must not be empty")
+	}
+	if id.Release == "" && n > 3 {
+		return invalid("release must not be empty")
+	}
+	if id.Version != "" && !versionPattern.MatchString(id.Version) {
+		return invalid("version %q must only contain letters, digits, ., _, + and -", id.Version)
+	}
+	if id.Release == "" && releasePattern.MatchString(id.Version) {
+		return invalid("%s is a release, which requires a version", id.Version)
+	}
+	if id.Release != "" && !releasePattern.MatchString(id.Release) {
+		return invalid("release %q must be a timestamp like 20180801003001", id.Release)
+	}
+	return id, nil
+}
+
+// String returns the package identifier as origin/name[/version[/release]].
+func (id PackageIdent) String() string {
+	parts := []string{id.Origin, id.Name}
+	if id.Version != "" {
+		parts = append(parts, id.Version)
+		if id.Release != "" {
+			parts = append(parts, id.Release)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// satisfiedBy reports whether the package other satisfies the identifier, so
+// every part set in id has the same value in other.
+func (id PackageIdent) satisfiedBy(other PackageIdent) bool {
+	return id.Origin == other.Origin &&
+		id.Name == other.Name &&
+		(id.Version == "" || id.Version == other.Version) &&
+		(id.Release == "" || id.Release == other.Release)
+}
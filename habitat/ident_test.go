@@ -0,0 +1,56 @@
+package habitat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePackageIdent(t *testing.T) {
+	cases := map[string]PackageIdent{
+		"core/redis":                        {Origin: "core", Name: "redis"},
+		"core/redis/4.0.10":                 {Origin: "core", Name: "redis", Version: "4.0.10"},
+		"core/redis/4.0.10/20180801003001":  {Origin: "core", Name: "redis", Version: "4.0.10", Release: "20180801003001"},
+		"my-origin/hab_sup/1.6.0-rc.1+meta": {Origin: "my-origin", Name: "hab_sup", Version: "1.6.0-rc.1+meta"},
+	}
+
+	for ident, expected := range cases {
+		id, err := parsePackageIdent(ident)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", ident, err)
+			continue
+		}
+		if id != expected {
+			t.Errorf("%s: expected %#v, got %#v", ident, expected, id)
+		}
+		if id.String() != ident {
+			t.Errorf("%s: unexpected string %s", ident, id.String())
+		}
+	}
+}
+
+func TestParsePackageIdent_invalid(t *testing.T) {
+	cases := map[string]string{
+		"redis":                                  "expected origin/name",
+		"core/redis/4.0.10/20180801003001/extra": "expected origin/name",
+		"/redis":                                 `origin ""`,
+		"core/re dis":                            `name "re dis"`,
+		"core/redis/":                            "version must not be empty",
+		"core/redis//20180801003001":             "a release requires a version",
+		"core/redis/1.0/":                        "release must not be empty",
+		"core/redis//":                           "version must not be empty",
+		"core/redis/20180801003001":              "is a release",
+		"core/redis/4.0;rm":                      `version "4.0;rm"`,
+		"core/redis/4.0.10/latest":               `release "latest"`,
+	}
+
+	for ident, expected := range cases {
+		_, err := parsePackageIdent(ident)
+		if err == nil {
+			t.Errorf("%s: expected an error", ident)
+			continue
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q in the error, got %v", ident, expected, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package habitat
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// initSystem describes how the supervisor is run as a service of a Linux init
+// system. The commands are passed the name of the service.
+type initSystem struct {
+	// path returns the location of the unit file or init script
+	path func(name string) string
+	// template of the unit file or init script
+	template string
+	// executable is set if the init script has to be executable
+	executable bool
+	// quote quotes the options of hab sup run in the template
+	quote func(string) string
+	// envLine renders a line of the environment file
+	envLine func(name, value string) string
+	// dropInDir returns the directory of drop-ins overriding the unit file
+	dropInDir func(name string) string
+
+	enable  func(name string) []*command
+	start   func(name string) []*command
+	restart func(name string) []*command
+	stop    func(name string) []*command
+	// cleanup runs after the unit file or init script has been removed
+	cleanup func(name string) []*command
+}
+
+const systemdUnit = `
+[Unit]
+Description=Habitat Supervisor
+{{ with .SystemdUnit -}}
+{{ if .After }}After={{ join .After " " }}
+{{ end -}}
+{{ if .Wants }}Wants={{ join .Wants " " }}
+{{ end -}}
+{{ end }}
+[Service]
+ExecStart=/bin/hab sup run {{ .SupOptions }}
+Restart=on-failure
+{{ if .BuilderAuthToken -}}
+EnvironmentFile={{ systemdQuote .EnvironmentFile }}
+{{ end -}}
+{{ with .SystemdUnit -}}
+{{ range $name, $value := .Environment -}}
+Environment={{ systemdEnvQuote (printf "%s=%s" $name $value) }}
+{{ end -}}
+{{ if .LimitNOFILE }}LimitNOFILE={{ .LimitNOFILE }}
+{{ end -}}
+{{ if .User }}User={{ .User }}
+{{ end -}}
+{{ if .Group }}Group={{ .Group }}
+{{ end -}}
+{{ if .RestartSec }}RestartSec={{ .RestartSec }}
+{{ end -}}
+{{ end }}
+[Install]
+WantedBy=default.target
+`
+
+const openrcScript = `#!/sbin/openrc-run
+
+description="Habitat Supervisor"
+command="/bin/hab"
+command_args={{ shellQuote (printf "sup run %s" .SupOptions) }}
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+output_log={{ shellQuote .LogFile }}
+error_log={{ shellQuote .LogFile }}
+{{ if .BuilderAuthToken -}}
+. {{ shellQuote .EnvironmentFile }}
+{{ end }}
+depend() {
+	need net
+}
+`
+
+const sysvinitScript = `#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{ .ServiceName }}
+# Required-Start:    $network $remote_fs
+# Required-Stop:     $network $remote_fs
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: Habitat Supervisor
+### END INIT INFO
+
+PIDFILE={{ shellQuote (printf "/var/run/%s.pid" .ServiceName) }}
+{{ if .BuilderAuthToken -}}
+. {{ shellQuote .EnvironmentFile }}
+{{ end }}
+running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2> /dev/null
+}
+
+start() {
+	running && return 0
+	nohup /bin/hab sup run {{ .SupOptions }} >> {{ shellQuote .LogFile }} 2>&1 < /dev/null &
+	echo $! > "$PIDFILE"
+}
+
+stop() {
+	running && kill "$(cat "$PIDFILE")"
+	rm -f "$PIDFILE"
+}
+
+case "$1" in
+	start) start ;;
+	stop) stop ;;
+	restart) stop; sleep 1; start ;;
+	status) running ;;
+	*) echo "Usage: $0 {start|stop|restart|status}"; exit 1 ;;
+esac
+`
+
+const runitScript = `#!/bin/sh
+{{ if .BuilderAuthToken -}}
+. {{ shellQuote .EnvironmentFile }}
+{{ end -}}
+exec /bin/hab sup run {{ .SupOptions }} >> {{ shellQuote .LogFile }} 2>&1
+`
+
+const upstartJob = `description "Habitat Supervisor"
+
+start on (local-filesystems and net-device-up IFACE!=lo)
+stop on runlevel [!2345]
+respawn
+
+script
+{{ if .BuilderAuthToken -}}
+	. {{ shellQuote .EnvironmentFile }}
+{{ end -}}
+	exec /bin/hab sup run {{ .SupOptions }} >> {{ shellQuote .LogFile }} 2>&1
+end script
+`
+
+// detectInitScript prints the init system of the target.
+const detectInitScript = `if [ -d /run/systemd/system ]; then echo systemd
+elif command -v openrc-run > /dev/null 2>&1 || [ -x /sbin/openrc-run ]; then echo openrc
+elif command -v initctl > /dev/null 2>&1 && initctl version 2> /dev/null | grep -q upstart; then echo upstart
+elif command -v runsvdir > /dev/null 2>&1 && [ -d /etc/sv ]; then echo runit
+elif [ -d /etc/init.d ]; then echo sysvinit
+else echo unmanaged
+fi`
+
+// runitRetryScript retries an sv command while runsv picks up a new service.
+const runitRetryScript = `for i in 1 2 3 4 5 6 7 8 9 10; do sv "$1" "$2" > /dev/null 2>&1 && exit 0; sleep 1; done; sv "$1" "$2"`
+
+// runitLinkScript links a service into the directory runsvdir watches, which
+// differs between distributions.
+const runitLinkScript = `for d in /var/service /etc/service /service; do if [ -d "$d" ]; then ln -sfn "$1" "$d/"; exit 0; fi; done; echo "No runit service directory found" >&2; exit 1`
+const runitUnlinkScript = `for d in /var/service /etc/service /service; do rm -f "$d/$(basename "$1")"; done`
+
+// systemdDropIn is the name of the drop-in holding the overrides of the unit.
+const systemdDropIn = "habitat.conf"
+
+// systemdEnvQuote quotes an assignment for the Environment setting, which
+// unlike ExecStart doesn't expand variables.
+func systemdEnvQuote(s string) string {
+	r := strings.NewReplacer("%", "%%", `\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// renderDropIn renders unit file overrides keyed by "Section.Key" as a drop-in.
+// It returns an empty string if there are no overrides.
+func renderDropIn(overrides map[string]string) string {
+	sections := map[string][]string{}
+	for key, value := range overrides {
+		parts := strings.SplitN(key, ".", 2)
+		sections[parts[0]] = append(sections[parts[0]], fmt.Sprintf("%s=%s", parts[1], value))
+	}
+
+	var b strings.Builder
+	for _, section := range []string{"Unit", "Service", "Install"} {
+		settings := sections[section]
+		if len(settings) == 0 {
+			continue
+		}
+		sort.Strings(settings)
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n%s\n", section, strings.Join(settings, "\n"))
+	}
+	return b.String()
+}
+
+// shellEnvLine renders an environment variable for a file sourced by sh.
+func shellEnvLine(name, value string) string {
+	return "export " + name + "=" + shellQuote(value)
+}
+
+// shScript returns a command running a shell script with arguments.
+func shScript(script string, args ...string) *command {
+	return newCommand("sh", "-c", script, "sh").Args(args...)
+}
+
+func runitDir(name string) string {
+	return path.Join("/etc/sv", name)
+}
+
+var initSystems = map[string]initSystem{
+	"systemd": {
+		path:     func(name string) string { return path.Join("/etc/systemd/system", name+".service") },
+		template: systemdUnit,
+		quote:    systemdQuote,
+		envLine: func(name, value string) string {
+			return name + "=" + envFileQuote(value)
+		},
+		dropInDir: func(name string) string { return path.Join("/etc/systemd/system", name+".service.d") },
+		enable: func(name string) []*command {
+			return []*command{
+				newCommand("systemctl", "daemon-reload"),
+				newCommand("systemctl", "enable", name+".service"),
+			}
+		},
+		start: func(name string) []*command {
+			return []*command{newCommand("systemctl", "start", name+".service")}
+		},
+		restart: func(name string) []*command {
+			return []*command{newCommand("systemctl", "restart", name+".service")}
+		},
+		stop: func(name string) []*command {
+			return []*command{
+				newCommand("systemctl", "stop", name+".service"),
+				newCommand("systemctl", "disable", name+".service"),
+			}
+		},
+		cleanup: func(name string) []*command {
+			return []*command{newCommand("systemctl", "daemon-reload")}
+		},
+	},
+	"openrc": {
+		path:       func(name string) string { return path.Join("/etc/init.d", name) },
+		template:   openrcScript,
+		executable: true,
+		quote:      shellQuote,
+		envLine:    shellEnvLine,
+		enable: func(name string) []*command {
+			return []*command{newCommand("rc-update", "add", name, "default")}
+		},
+		start: func(name string) []*command {
+			return []*command{shScript(`rc-service "$1" status > /dev/null 2>&1 || rc-service "$1" start`, name)}
+		},
+		restart: func(name string) []*command {
+			return []*command{newCommand("rc-service", name, "restart")}
+		},
+		stop: func(name string) []*command {
+			return []*command{
+				newCommand("rc-service", name, "stop"),
+				newCommand("rc-update", "del", name, "default"),
+			}
+		},
+	},
+	"sysvinit": {
+		path:       func(name string) string { return path.Join("/etc/init.d", name) },
+		template:   sysvinitScript,
+		executable: true,
+		quote:      shellQuote,
+		envLine:    shellEnvLine,
+		enable: func(name string) []*command {
+			return []*command{shScript(`if command -v update-rc.d > /dev/null 2>&1; then update-rc.d "$1" defaults; else chkconfig --add "$1"; fi`, name)}
+		},
+		start: func(name string) []*command {
+			return []*command{newCommand(path.Join("/etc/init.d", name), "start")}
+		},
+		restart: func(name string) []*command {
+			return []*command{newCommand(path.Join("/etc/init.d", name), "restart")}
+		},
+		stop: func(name string) []*command {
+			return []*command{
+				newCommand(path.Join("/etc/init.d", name), "stop"),
+				shScript(`if command -v update-rc.d > /dev/null 2>&1; then update-rc.d -f "$1" remove; else chkconfig --del "$1"; fi`, name),
+			}
+		},
+	},
+	"runit": {
+		path:       func(name string) string { return path.Join(runitDir(name), "run") },
+		template:   runitScript,
+		executable: true,
+		quote:      shellQuote,
+		envLine:    shellEnvLine,
+		enable: func(name string) []*command {
+			return []*command{shScript(runitLinkScript, runitDir(name))}
+		},
+		start: func(name string) []*command {
+			return []*command{shScript(runitRetryScript, "up", runitDir(name))}
+		},
+		restart: func(name string) []*command {
+			return []*command{shScript(runitRetryScript, "restart", runitDir(name))}
+		},
+		stop: func(name string) []*command {
+			return []*command{
+				newCommand("sv", "down", runitDir(name)),
+				shScript(runitUnlinkScript, runitDir(name)),
+			}
+		},
+		cleanup: func(name string) []*command {
+			return []*command{newCommand("rm", "-rf", runitDir(name))}
+		},
+	},
+	"upstart": {
+		path:     func(name string) string { return path.Join("/etc/init", name+".conf") },
+		template: upstartJob,
+		quote:    shellQuote,
+		envLine:  shellEnvLine,
+		enable: func(name string) []*command {
+			return []*command{newCommand("initctl", "reload-configuration")}
+		},
+		start: func(name string) []*command {
+			return []*command{shScript(`initctl status "$1" | grep -q start/running || initctl start "$1"`, name)}
+		},
+		restart: func(name string) []*command {
+			return []*command{shScript(`initctl restart "$1" || initctl start "$1"`, name)}
+		},
+		stop: func(name string) []*command {
+			return []*command{newCommand("initctl", "stop", name)}
+		},
+		cleanup: func(name string) []*command {
+			return []*command{newCommand("initctl", "reload-configuration")}
+		},
+	},
+}
@@ -0,0 +1,139 @@
+package habitat
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestInitSystems_shellSyntax(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	for _, name := range []string{"openrc", "sysvinit", "runit"} {
+		for _, input := range hostileInputs {
+			p := &linuxPlatform{&provisioner{ServiceName: "hab-supervisor", Peer: input, BuilderAuthToken: input}}
+			content, err := p.renderInitScript(initSystems[name], p.supOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(sh, "-n")
+			cmd.Stdin = bytes.NewReader(content)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s script for %q is invalid: %s\n%s", name, input, out, content)
+			}
+		}
+	}
+}
+
+func TestInitSystems_openrcArgs(t *testing.T) {
+	commandArgs := regexp.MustCompile(`(?ms)^command_args=.*?\ncommand_background=`)
+
+	for _, input := range hostileInputs {
+		p := &linuxPlatform{&provisioner{ServiceName: "hab-supervisor", Peer: input, Events: input}}
+		content, err := p.renderInitScript(initSystems["openrc"], p.supOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// openrc-run evaluates command_args when starting the command
+		line := strings.TrimSuffix(string(commandArgs.Find(content)), "\ncommand_background=")
+		got := testShell(t, line+"\n"+`eval "hab $command_args"`, nil)
+
+		expected := append([]string{"", "sup", "run"}, p.supOptions()...)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %q, got %q", line, expected, got)
+		}
+	}
+}
+
+func TestInitSystems_runitArgs(t *testing.T) {
+	for _, input := range hostileInputs {
+		p := &linuxPlatform{&provisioner{ServiceName: "hab-supervisor", Peer: input, Events: input}}
+		content, err := p.renderInitScript(initSystems["runit"], p.supOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		script := strings.Replace(string(content), "/bin/hab", "hab", 1)
+		script = strings.Replace(script, ">> /hab/sup/default/sup.log 2>&1", "", 1)
+		got := testShell(t, script, nil)
+
+		expected := append([]string{"", "sup", "run"}, p.supOptions()...)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected %q, got %q", script, expected, got)
+		}
+	}
+}
+
+func TestInitSystems_systemdUnit(t *testing.T) {
+	p := &linuxPlatform{&provisioner{
+		ServiceName:      "hab-supervisor",
+		BuilderAuthToken: "token",
+		SystemdUnit: &SystemdUnit{
+			Environment: map[string]string{"HAB_STUDIO": "it's \"quoted\" 50%", "A": "1"},
+			LimitNOFILE: "65536",
+			User:        "hab",
+			After:       []string{"network-online.target", "docker.service"},
+			Wants:       []string{"network-online.target"},
+			RestartSec:  "5s",
+		},
+	}}
+	content, err := p.renderInitScript(initSystems["systemd"], []string{"--peer", "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+[Unit]
+Description=Habitat Supervisor
+After=network-online.target docker.service
+Wants=network-online.target
+
+[Service]
+ExecStart=/bin/hab sup run --peer 10.0.0.1
+Restart=on-failure
+EnvironmentFile=/etc/default/hab-supervisor
+Environment="A=1"
+Environment="HAB_STUDIO=it's \"quoted\" 50%%"
+LimitNOFILE=65536
+User=hab
+RestartSec=5s
+
+[Install]
+WantedBy=default.target
+`
+	if string(content) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, content)
+	}
+}
+
+func TestRenderDropIn(t *testing.T) {
+	overrides := map[string]string{
+		"Service.TimeoutStopSec": "30",
+		"Unit.StartLimitBurst":   "5",
+		"Service.Nice":           "-5",
+	}
+
+	expected := "[Unit]\nStartLimitBurst=5\n\n[Service]\nNice=-5\nTimeoutStopSec=30\n"
+	if got := renderDropIn(overrides); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if got := renderDropIn(nil); got != "" {
+		t.Errorf("expected no drop-in, got %q", got)
+	}
+}
+
+func TestSystemdEnvLine(t *testing.T) {
+	// The environment file is not subject to the escaping of unit files
+	expected := `HAB_AUTH_TOKEN="a\$b%c"`
+	if got := initSystems["systemd"].envLine("HAB_AUTH_TOKEN", "a$b%c"); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
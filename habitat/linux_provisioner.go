@@ -2,237 +2,597 @@ package habitat
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/hashicorp/terraform/communicator"
 	"github.com/hashicorp/terraform/terraform"
 	linereader "github.com/mitchellh/go-linereader"
 )
 
+// linuxPlatform provisions Linux targets over ssh.
+type linuxPlatform struct {
+	*provisioner
+}
+
 const linuxInstallURL = "https://raw.githubusercontent.com/habitat-sh/habitat/master/components/hab/install.sh"
-const systemdUnit = `
-[Unit]
-Description=Habitat Supervisor
 
-[Service]
-ExecStart=/bin/hab sup run {{ .SupOptions }}
-Restart=on-failure
-{{ if .BuilderAuthToken -}}
-Environment="HAB_AUTH_TOKEN={{ .BuilderAuthToken }}"
-{{ end -}}
+func (p *linuxPlatform) run(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, cmd *command) error {
+	return p.runCommand(ctx, o, comm, cmd.String(), cmd.Stdin())
+}
+
+func (p *linuxPlatform) output(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, cmd *command) (string, error) {
+	return p.outputCommand(ctx, o, comm, cmd.String(), cmd.Stdin())
+}
+
+func (p *linuxPlatform) probe(ctx context.Context, comm communicator.Communicator, cmd *command) (string, error) {
+	return p.probeCommand(ctx, comm, cmd.String())
+}
 
-[Install]
-WantedBy=default.target
-`
+// habCtl returns a hab command that talks to the control gateway of the
+// supervisor. A supervisor other than the default one has its own gateway
+// secret, which is read from its state directory on the target.
+func (p *linuxPlatform) habCtl(args ...string) *command {
+	cmd := newCommand("hab").Args(args...).Option("--remote-sup", p.ListenCtl)
+	if p.supName() != "default" {
+		secret := path.Join("/hab/sup", p.supName(), "CTL_SECRET")
+		script := `HAB_CTL_SECRET="$(cat "$1")" && export HAB_CTL_SECRET && shift && exec "$@"`
+		cmd = newCommand("sh", "-c", script, "sh", secret).Args(cmd.args...)
+	}
+	return cmd.Sudo(p.UseSudo)
+}
 
-func (p *provisioner) linuxUploadRingKey(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
-	command := fmt.Sprintf("echo '%s' | hab ring key import", p.RingKeyContent)
-	if p.UseSudo {
-		command = fmt.Sprintf("echo '%s' | sudo hab ring key import", p.RingKeyContent)
+// installedVersion returns the version of the installed hab binary, or false
+// if hab isn't installed.
+func (p *linuxPlatform) installedVersion(ctx context.Context, comm communicator.Communicator) (string, bool) {
+	cmd := newCommand("sh", "-c", "command -v hab > /dev/null && hab --version").
+		Env("HAB_LICENSE", "accept-no-persist")
+	out, err := p.probe(ctx, comm, cmd)
+	if err != nil {
+		return "", false
 	}
-	return p.runCommand(o, comm, command)
+	return parseHabVersion(out)
 }
 
-func (p *provisioner) linuxInstallHab(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
-	// Build the install command
-	command := fmt.Sprintf("curl -L0 %s > install.sh", linuxInstallURL)
-	if err := p.runCommand(o, comm, command); err != nil {
-		return err
+// serviceStatus returns the state of service, or false if it isn't loaded.
+func (p *linuxPlatform) serviceStatus(ctx context.Context, comm communicator.Communicator, service Service) (serviceStatus, bool) {
+	out, err := p.probe(ctx, comm, p.habCtl("svc", "status", service.Name))
+	if err != nil {
+		return serviceStatus{}, false
+	}
+	status, ok := parseServiceStatus(out)
+	if !ok {
+		return status, false
 	}
 
-	// Run the install script
-	if p.Version == "" {
-		command = fmt.Sprintf("env HAB_NONINTERACTIVE=true bash ./install.sh ")
-	} else {
-		command = fmt.Sprintf("env HAB_NONINTERACTIVE=true bash ./install.sh -v %s", p.Version)
+	spec := path.Join("/hab/sup", p.supName(), "specs", service.Ident.Name+".spec")
+	if out, err := p.probe(ctx, comm, newCommand("cat", spec).Sudo(p.UseSudo)); err == nil {
+		status.Spec, _ = parseServiceSpec(out)
 	}
+	return status, true
+}
 
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo %s", command)
+// remoteChecksum returns the SHA-256 checksum of file on the target, or an
+// empty string if it can't be read.
+func (p *linuxPlatform) remoteChecksum(ctx context.Context, comm communicator.Communicator, file string) string {
+	out, err := p.probe(ctx, comm, newCommand("sha256sum", file).Sudo(p.UseSudo))
+	fields := strings.Fields(out)
+	if err != nil || len(fields) == 0 {
+		return ""
 	}
+	return strings.ToLower(fields[0])
+}
 
-	if err := p.runCommand(o, comm, command); err != nil {
-		return err
+// writeIfChanged writes content to dst unless the file already has the same
+// content, and reports whether it was written.
+func (p *linuxPlatform) writeIfChanged(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content []byte, secret bool) (bool, error) {
+	if p.remoteChecksum(ctx, comm, dst) == sha256Hex(content) {
+		o.Output(dst + " is unchanged")
+		return false, nil
+	}
+
+	o.Output("Writing " + dst)
+	if secret {
+		return true, p.writeSecretFile(ctx, o, comm, dst, bytes.NewReader(content))
+	}
+	return true, p.UploadFile(ctx, o, comm, dst, bytes.NewReader(content))
+}
+
+// writeSecretFile writes content to a file only readable by its owner. The
+// content is passed on stdin, so it never shows up in a command line or a
+// temporary file.
+func (p *linuxPlatform) writeSecretFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content io.Reader) error {
+	script := `umask 077 && mkdir -p "$(dirname "$1")" && cat > "$1"`
+	cmd := newCommand("sh", "-c", script, "sh", dst).
+		Sudo(p.UseSudo).
+		Input(content)
+	return p.run(ctx, o, comm, cmd)
+}
+
+func (p *linuxPlatform) UploadRingKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	cmd := newCommand("hab", "ring", "key", "import").
+		Sudo(p.UseSudo).
+		Input(strings.NewReader(p.RingKeyContent))
+	return p.run(ctx, o, comm, cmd)
+}
+
+func (p *linuxPlatform) InstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	if version, ok := p.installedVersion(ctx, comm); ok {
+		if p.habSatisfied(version) {
+			o.Output(fmt.Sprintf("Habitat %s is already installed, skipping installation", version))
+			return nil
+		}
+		o.Output(fmt.Sprintf("Habitat %s is installed, updating to %s", version, p.Version))
+	}
+
+	if p.Offline != nil {
+		if err := p.installHabArchive(ctx, o, comm); err != nil {
+			return err
+		}
+	} else {
+		if err := p.runInstallScript(ctx, o, comm); err != nil {
+			return err
+		}
 	}
 
 	// Accept the license
 	if p.AcceptLicense {
-		command = fmt.Sprintf("export HAB_LICENSE=accept; hab -V")
-		if p.UseSudo {
-			command = fmt.Sprintf("sudo HAB_LICENSE=accept hab -V")
-		}
-		if err := p.runCommand(o, comm, command); err != nil {
+		cmd := newCommand("hab", "-V").Env("HAB_LICENSE", "accept").Sudo(p.UseSudo)
+		if err := p.run(ctx, o, comm, cmd); err != nil {
 			return err
 		}
 	}
 
-	if err := p.createHabUser(o, comm); err != nil {
+	return p.createHabUser(ctx, o, comm)
+}
+
+func (p *linuxPlatform) runInstallScript(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	installURL := linuxInstallURL
+	if p.InstallScriptURL != "" {
+		installURL = p.InstallScriptURL
+	}
+
+	// Download the install script
+	download := newCommand("curl", "--fail", "-L0", installURL, "-o", "install.sh")
+	err := p.retry(ctx, o, stepDownloadInstaller, p.Retry, func() error {
+		return p.run(ctx, o, comm, download)
+	})
+	if err != nil {
 		return err
 	}
 
-	return p.runCommand(o, comm, fmt.Sprintf("rm -f install.sh"))
+	// Verify the install script before running it
+	if p.InstallChecksum != "" {
+		if err := p.verifyChecksum(ctx, o, comm, "install.sh", installURL); err != nil {
+			p.run(ctx, o, comm, newCommand("rm", "-f", "install.sh"))
+			return err
+		}
+	}
+
+	// Run the install script
+	cmd := newCommand("bash", "./install.sh").
+		Env("HAB_NONINTERACTIVE", "true").
+		Option("-v", p.Version).
+		Sudo(p.UseSudo)
+	err = p.retry(ctx, o, stepInstallHab, p.Retry, func() error {
+		return p.run(ctx, o, comm, cmd)
+	})
+	if err != nil {
+		return err
+	}
 
+	return p.run(ctx, o, comm, newCommand("rm", "-f", "install.sh"))
 }
 
-func (p *provisioner) linuxStartHab(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
-	// Install the supervisor first
-	var command string
-	if p.Version == "" {
-		command += fmt.Sprintf("hab install core/hab-sup")
-	} else {
-		command += fmt.Sprintf("hab install core/hab-sup/%s", p.Version)
+// verifyChecksum compares the SHA-256 checksum of file on the target with the
+// configured install checksum.
+func (p *linuxPlatform) verifyChecksum(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, file, source string) error {
+	if p.RenderOnly != "" {
+		// The rendered script verifies the checksum itself
+		return p.run(ctx, o, comm, shScript(`echo "$1  $2" | sha256sum -c -`, p.InstallChecksum, file))
+	}
+
+	out, err := p.output(ctx, o, comm, newCommand("sha256sum", file))
+	if err != nil {
+		return fmt.Errorf("Error computing checksum of %s: %v", file, err)
 	}
 
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo -E %s", command)
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return fmt.Errorf("Error computing checksum of %s: no output from sha256sum", file)
+	}
+	if actual := strings.ToLower(fields[0]); actual != p.InstallChecksum {
+		return fmt.Errorf("Checksum mismatch for %s: expected SHA-256 %s, got %s", source, p.InstallChecksum, actual)
 	}
 
-	command = fmt.Sprintf("env HAB_NONINTERACTIVE=true %s", command)
+	o.Output("Verified checksum of " + file)
+	return nil
+}
 
-	if err := p.runCommand(o, comm, command); err != nil {
+// installHabArchive installs the hab binary from a locally supplied archive.
+func (p *linuxPlatform) installHabArchive(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	archive := "/tmp/hab.tar.gz"
+	if err := p.uploadLocalFile(ctx, o, comm, p.Offline.HabArchive, archive); err != nil {
 		return err
 	}
 
-	// Build up sup options
-	options := ""
-	if p.PermanentPeer {
-		options += " -I"
+	script := `mkdir -p "$2" && tar -xzf "$1" -C "$2" --strip-components=1 && install -m 0755 "$2/hab" /bin/hab && rm -rf "$1" "$2"`
+	cmd := newCommand("sh", "-c", script, "sh", archive, "/tmp/hab-archive").Sudo(p.UseSudo)
+	return p.run(ctx, o, comm, cmd)
+}
+
+// installHart uploads a locally supplied .hart file and installs it.
+func (p *linuxPlatform) installHart(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, hart string) error {
+	dst := path.Join("/tmp", filepath.Base(hart))
+	if err := p.uploadLocalFile(ctx, o, comm, hart, dst); err != nil {
+		return err
 	}
 
-	if p.ListenGossip != "" {
-		options += fmt.Sprintf(" --listen-gossip %s", p.ListenGossip)
+	cmd := newCommand("hab", "pkg", "install", dst).
+		Env("HAB_NONINTERACTIVE", "true").
+		Sudo(p.UseSudo)
+	if err := p.run(ctx, o, comm, cmd); err != nil {
+		return err
 	}
 
-	if p.ListenHTTP != "" {
-		options += fmt.Sprintf(" --listen-http %s", p.ListenHTTP)
+	return p.run(ctx, o, comm, newCommand("rm", "-f", dst).Sudo(p.UseSudo))
+}
+
+func (p *linuxPlatform) StartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	// Install the supervisor first
+	installed, err := p.installSupervisor(ctx, o, comm)
+	if err != nil {
+		return err
 	}
 
-	if p.Peer != "" {
-		options += fmt.Sprintf(" --peer %s", p.Peer)
+	serviceType, err := p.serviceType(ctx, o, comm)
+	if err != nil {
+		return err
 	}
 
-	if p.RingKey != "" {
-		options += fmt.Sprintf(" --ring %s", p.RingKey)
+	options := p.supOptions()
+	if serviceType == "unmanaged" {
+		return p.startHabUnmanaged(ctx, o, comm, options, installed)
+	}
+	init, ok := initSystems[serviceType]
+	if !ok {
+		return errors.New("Unsupported service type")
 	}
+	return p.startHabInit(ctx, o, comm, init, options, installed)
+}
 
-	if p.URL != "" {
-		options += fmt.Sprintf(" --url %s", p.URL)
+// serviceType returns the configured service type, or the init system detected
+// on the target for the auto service type.
+func (p *linuxPlatform) serviceType(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) (string, error) {
+	if p.ServiceType != "auto" {
+		return p.ServiceType, nil
 	}
 
-	if p.Channel != "" {
-		options += fmt.Sprintf(" --channel %s", p.Channel)
+	out, err := p.output(ctx, o, comm, newCommand("sh", "-c", detectInitScript))
+	if err != nil {
+		return "", fmt.Errorf("Error detecting the init system: %v", err)
 	}
+	p.ServiceType = strings.TrimSpace(out)
+	o.Output("Detected init system: " + p.ServiceType)
+	return p.ServiceType, nil
+}
 
-	if p.Events != "" {
-		options += fmt.Sprintf(" --events %s", p.Events)
+// linuxQueryScript requests a URL with curl or, if curl isn't available, wget.
+// It prints the body followed by the status code on a line of its own. wget
+// doesn't report the status code, so it is approximated from the exit code.
+const linuxQueryScript = `if command -v curl > /dev/null 2>&1; then
+  curl -s -w '\n%{http_code}' "$1"
+else
+  wget -q -O - "$1"; rc=$?
+  case $rc in 0) code=200 ;; 6) code=401 ;; 8) code=500 ;; *) exit $rc ;; esac
+  printf '\n%s' "$code"
+fi`
+
+func (p *linuxPlatform) QueryHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string) (int, string, error) {
+	url := p.gatewayURL(endpoint)
+	out, err := p.probe(ctx, comm, newCommand("sh", "-c", linuxQueryScript, "sh", url))
+	if err != nil {
+		return 0, "", fmt.Errorf("%s did not respond: %v", url, err)
 	}
+	return parseGatewayResponse(out)
+}
+
+// linuxPollScript runs the query script $4 for the URL $2 until the response
+// has the status code $3, or any if it is empty, giving up after $1 seconds.
+const linuxPollScript = `end=$(( $(date +%s) + $1 ))
+while :; do
+  code="$(sh -c "$4" sh "$2" 2> /dev/null | tail -n 1)"
+  case "$code" in
+  ""|000) ;;
+  *) if [ -z "$3" ] || [ "$code" = "$3" ]; then exit 0; fi ;;
+  esac
+  if [ "$(date +%s)" -ge "$end" ]; then
+    echo "$2 did not respond within $1 seconds" >&2
+    exit 1
+  fi
+  sleep 2
+done`
+
+func (p *linuxPlatform) PollHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string, code int, timeout time.Duration) error {
+	expected := ""
+	if code != 0 {
+		expected = strconv.Itoa(code)
+	}
+	seconds := strconv.Itoa(int(timeout.Seconds()))
+	return p.run(ctx, o, comm, shScript(linuxPollScript, seconds, p.gatewayURL(endpoint), expected, linuxQueryScript))
+}
 
-	if p.OverrideName != "" {
-		options += fmt.Sprintf(" --override-name %s", p.OverrideName)
+func (p *linuxPlatform) HabLog(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, lines int) (string, error) {
+	serviceType, err := p.serviceType(ctx, o, comm)
+	if err != nil {
+		return "", err
 	}
 
-	if p.Organization != "" {
-		options += fmt.Sprintf(" --org %s", p.Organization)
+	cmd := newCommand("tail", "-n", fmt.Sprint(lines), path.Join("/hab/sup", p.supName(), "sup.log"))
+	if serviceType == "systemd" {
+		cmd = newCommand("journalctl", "--no-pager", "-n", fmt.Sprint(lines), "-u", p.ServiceName+".service")
 	}
+	return p.probe(ctx, comm, cmd.Sudo(p.UseSudo))
+}
 
-	p.SupOptions = options
+// installSupervisor installs the supervisor package, unless it is already
+// installed, and reports whether it installed it.
+func (p *linuxPlatform) installSupervisor(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) (bool, error) {
+	ident := "core/hab-sup"
+	if p.Version != "" && p.Offline == nil {
+		ident = fmt.Sprintf("core/hab-sup/%s", p.Version)
+	}
+	if _, err := p.probe(ctx, comm, newCommand("hab", "pkg", "path", ident)); err == nil {
+		o.Output(ident + " is already installed")
+		return false, nil
+	}
 
-	switch p.ServiceType {
-	case "unmanaged":
-		return p.startHabUnmanaged(o, comm, options)
-	case "systemd":
-		return p.startHabSystemd(o, comm, options)
-	default:
-		return errors.New("Unsupported service type")
+	if p.Offline != nil {
+		if err := p.installHart(ctx, o, comm, p.Offline.HabLauncher); err != nil {
+			return false, err
+		}
+		return true, p.installHart(ctx, o, comm, p.Offline.HabSup)
 	}
+
+	cmd := newCommand("hab", "install", ident).
+		Env("HAB_NONINTERACTIVE", "true").
+		Sudo(p.UseSudo)
+	return true, p.retry(ctx, o, stepInstallPackage, p.Retry, func() error {
+		return p.run(ctx, o, comm, cmd)
+	})
 }
 
-func (p *provisioner) startHabUnmanaged(o terraform.UIOutput, comm communicator.Communicator, options string) error {
+func (p *linuxPlatform) startHabUnmanaged(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, options []string, installed bool) error {
+	if _, err := p.probe(ctx, comm, p.habCtl("sup", "status")); err == nil {
+		msg := "The habitat supervisor is already running"
+		if installed {
+			// There is no service to restart it with
+			msg += ", restart it to run the newly installed version"
+		}
+		o.Output(msg)
+		return nil
+	}
+
 	// Create the sup directory for the log file
-	var command string
-	var token string
-	if p.UseSudo {
-		command = "sudo mkdir -p /hab/sup/default && sudo chmod o+w /hab/sup/default"
-	} else {
-		command = "mkdir -p /hab/sup/default && chmod o+w /hab/sup/default"
+	supDir := path.Join("/hab/sup", p.supName())
+	mkdir := newCommand("mkdir", "-p", supDir).Sudo(p.UseSudo)
+	chmod := newCommand("chmod", "o+w", supDir).Sudo(p.UseSudo)
+	if err := p.runCommand(ctx, o, comm, fmt.Sprintf("%s && %s", mkdir, chmod), nil); err != nil {
+		return err
+	}
+
+	sup := newCommand("hab", "sup", "run").
+		Args(options...).
+		SecretEnv("HAB_AUTH_TOKEN", p.BuilderAuthToken).
+		Sudo(p.UseSudo)
+	log := shellQuote(path.Join(supDir, "sup.log"))
+	command := fmt.Sprintf("(setsid %s > %s 2>&1 < /dev/null &) ; sleep 1", sup, log)
+	if sup.HasSecrets() {
+		// Background jobs get their stdin from /dev/null, so the secrets are
+		// handed to the supervisor command on another file descriptor.
+		command = fmt.Sprintf("(setsid %s > %s 2>&1 <&3 3<&- &) 3<&0 ; sleep 1", sup, log)
+	}
+	return p.runCommand(ctx, o, comm, command, sup.Stdin())
+}
+
+// startHabInit installs the supervisor as a service of an init system and
+// starts it. The service is restarted if its configuration changed, or if a
+// new supervisor package was installed.
+func (p *linuxPlatform) startHabInit(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, init initSystem, options []string, installed bool) error {
+	content, err := p.renderInitScript(init, options)
+	if err != nil {
+		return err
 	}
-	if err := p.runCommand(o, comm, command); err != nil {
+
+	scriptPath := init.path(p.ServiceName)
+	mkdir := newCommand("mkdir", "-p", path.Dir(scriptPath), path.Join("/hab/sup", p.supName())).Sudo(p.UseSudo)
+	if err := p.run(ctx, o, comm, mkdir); err != nil {
 		return err
 	}
 
+	// Keep the auth token out of the world readable init script
+	envChanged := false
 	if p.BuilderAuthToken != "" {
-		token = fmt.Sprintf("env HAB_AUTH_TOKEN=%s", p.BuilderAuthToken)
+		env := init.envLine("HAB_AUTH_TOKEN", p.BuilderAuthToken) + "\n"
+		envChanged, err = p.writeIfChanged(ctx, o, comm, path.Join("/etc/default", p.ServiceName), []byte(env), true)
+		if err != nil {
+			return err
+		}
+	}
+
+	scriptChanged, err := p.writeIfChanged(ctx, o, comm, scriptPath, content, false)
+	if err != nil {
+		return err
+	}
+	if scriptChanged && init.executable {
+		if err := p.run(ctx, o, comm, newCommand("chmod", "0755", scriptPath).Sudo(p.UseSudo)); err != nil {
+			return err
+		}
+	}
+
+	if init.dropInDir != nil {
+		dropInChanged, err := p.writeDropIn(ctx, o, comm, init.dropInDir(p.ServiceName))
+		if err != nil {
+			return err
+		}
+		scriptChanged = scriptChanged || dropInChanged
 	}
 
-	if p.UseSudo {
-		command = fmt.Sprintf("(%s setsid sudo -E hab sup run %s > /hab/sup/default/sup.log 2>&1 &) ; sleep 1", token, options)
+	commands := init.enable(p.ServiceName)
+	if scriptChanged || envChanged || installed {
+		// Pick up the changed configuration or supervisor
+		commands = append(commands, init.restart(p.ServiceName)...)
 	} else {
-		command = fmt.Sprintf("(%s setsid hab sup run %s > /hab/sup/default/sup.log 2>&1 <&1 &) ; sleep 1", token, options)
+		// Start the supervisor in case it was stopped, which is a no-op otherwise
+		commands = append(commands, init.start(p.ServiceName)...)
 	}
-	return p.runCommand(o, comm, command)
+	return p.runAll(ctx, o, comm, commands)
 }
 
-func (p *provisioner) startHabSystemd(o terraform.UIOutput, comm communicator.Communicator, options string) error {
+// writeDropIn writes the unit file overrides to a drop-in in dir, or removes a
+// previously written drop-in if there are none, and reports whether it changed.
+func (p *linuxPlatform) writeDropIn(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dir string) (bool, error) {
+	dst := path.Join(dir, systemdDropIn)
+	var overrides map[string]string
+	if p.SystemdUnit != nil {
+		overrides = p.SystemdUnit.Overrides
+	}
+
+	content := renderDropIn(overrides)
+	if content == "" {
+		if p.remoteChecksum(ctx, comm, dst) == "" {
+			return false, nil
+		}
+		o.Output("Removing " + dst)
+		return true, p.run(ctx, o, comm, newCommand("rm", "-f", dst).Sudo(p.UseSudo))
+	}
+
+	if err := p.run(ctx, o, comm, newCommand("mkdir", "-p", dir).Sudo(p.UseSudo)); err != nil {
+		return false, err
+	}
+	return p.writeIfChanged(ctx, o, comm, dst, []byte(content), false)
+}
+
+// renderInitScript renders the unit file or init script running the supervisor
+// with options.
+func (p *linuxPlatform) renderInitScript(init initSystem, options []string) ([]byte, error) {
+	p.SupOptions = joinArgs(options, init.quote)
+
 	// Create a new template and parse the client config into it
-	unitString := template.Must(template.New("hab-supervisor.service").Parse(systemdUnit))
+	name := path.Base(init.path(p.ServiceName))
+	script := template.Must(template.New(name).
+		Funcs(template.FuncMap{
+			"systemdQuote":    systemdQuote,
+			"systemdEnvQuote": systemdEnvQuote,
+			"shellQuote":      shellQuote,
+			"join":            strings.Join,
+		}).
+		Parse(init.template))
+
+	data := struct {
+		*linuxPlatform
+		EnvironmentFile string
+		LogFile         string
+	}{p, path.Join("/etc/default", p.ServiceName), path.Join("/hab/sup", p.supName(), "sup.log")}
 
 	var buf bytes.Buffer
-	err := unitString.Execute(&buf, p)
-	if err != nil {
-		return fmt.Errorf("Error executing %s template: %s", "hab-supervisor.service", err)
+	if err := script.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("Error executing %s template: %s", name, err)
 	}
+	return buf.Bytes(), nil
+}
 
-	var command string
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo echo '%s' | sudo tee /etc/systemd/system/%s.service > /dev/null", &buf, p.ServiceName)
-	} else {
-		command = fmt.Sprintf("echo '%s' | tee /etc/systemd/system/%s.service > /dev/null", &buf, p.ServiceName)
+// runAll runs commands one after the other, until one of them fails.
+func (p *linuxPlatform) runAll(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, commands []*command) error {
+	var parts []string
+	for _, cmd := range commands {
+		parts = append(parts, cmd.Sudo(p.UseSudo).String())
 	}
+	return p.runCommand(ctx, o, comm, strings.Join(parts, " && "), nil)
+}
+
+func (p *linuxPlatform) DepartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	memberID := path.Join("/hab/sup", p.supName(), "MEMBER_ID")
+	depart := p.habCtl("sup", "depart")
+	cmd := newCommand("sh", "-c", `member="$(cat "$1")" && shift && exec "$@" "$member"`, "sh", memberID).
+		Args(depart.args...).
+		Sudo(p.UseSudo)
+	return p.run(ctx, o, comm, cmd)
+}
 
-	if err := p.runCommand(o, comm, command); err != nil {
+func (p *linuxPlatform) StopHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	serviceType, err := p.serviceType(ctx, o, comm)
+	if err != nil {
 		return err
 	}
 
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo systemctl enable hab-supervisor && sudo systemctl start hab-supervisor")
-	} else {
-		command = fmt.Sprintf("systemctl enable hab-supervisor && systemctl start hab-supervisor")
+	if serviceType == "unmanaged" {
+		return p.run(ctx, o, comm, newCommand("hab", "sup", "term").Option("--override-name", p.OverrideName).Sudo(p.UseSudo))
 	}
-	return p.runCommand(o, comm, command)
+	init, ok := initSystems[serviceType]
+	if !ok {
+		return errors.New("Unsupported service type")
+	}
+
+	commands := init.stop(p.ServiceName)
+	commands = append(commands, newCommand("rm", "-f", init.path(p.ServiceName), path.Join("/etc/default", p.ServiceName)))
+	if init.dropInDir != nil {
+		commands = append(commands, newCommand("rm", "-rf", init.dropInDir(p.ServiceName)))
+	}
+	if init.cleanup != nil {
+		commands = append(commands, init.cleanup(p.ServiceName)...)
+	}
+	return p.runAll(ctx, o, comm, commands)
+}
+
+func (p *linuxPlatform) UninstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	return p.run(ctx, o, comm, newCommand("rm", "-rf", "/hab", "/bin/hab").Sudo(p.UseSudo))
 }
 
-func (p *provisioner) createHabUser(o terraform.UIOutput, comm communicator.Communicator) error {
+func (p *linuxPlatform) createHabUser(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
 	addUser := false
 	// Install busybox to get us the user tools we need
-	command := fmt.Sprintf("env HAB_NONINTERACTIVE=true hab install core/busybox")
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo %s", command)
-	}
-	if err := p.runCommand(o, comm, command); err != nil {
-		return err
+	if p.Offline != nil {
+		if p.Offline.Busybox == "" {
+			return errors.New("offline.busybox is required to install Habitat on linux")
+		}
+		if err := p.installHart(ctx, o, comm, p.Offline.Busybox); err != nil {
+			return err
+		}
+	} else {
+		cmd := newCommand("hab", "install", "core/busybox").
+			Env("HAB_NONINTERACTIVE", "true").
+			Sudo(p.UseSudo)
+		err := p.retry(ctx, o, stepInstallPackage, p.Retry, func() error {
+			return p.run(ctx, o, comm, cmd)
+		})
+		if err != nil {
+			return err
+		}
 	}
 
 	// Check for existing hab user
-	command = fmt.Sprintf("hab pkg exec core/busybox id hab")
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo %s", command)
+	cmd := newCommand("hab", "pkg", "exec", "core/busybox", "id", "hab").Sudo(p.UseSudo)
+	add := newCommand("hab", "pkg", "exec", "core/busybox", "adduser", "-D", "-g", "", "hab").Sudo(p.UseSudo)
+	if p.RenderOnly != "" {
+		// The rendered script checks for the user itself
+		return p.runCommand(ctx, o, comm, cmd.String()+" > /dev/null 2>&1 || "+add.String(), nil)
 	}
-	if err := p.runCommand(o, comm, command); err != nil {
+	if err := p.run(ctx, o, comm, cmd); err != nil {
 		o.Output("No existing hab user detected, creating...")
 		addUser = true
 	}
 
 	if addUser {
-		command = fmt.Sprintf("hab pkg exec core/busybox adduser -D -g \"\" hab")
-		if p.UseSudo {
-			command = fmt.Sprintf("sudo %s", command)
-		}
-		return p.runCommand(o, comm, command)
+		return p.run(ctx, o, comm, add)
 	}
 
 	return nil
@@ -241,121 +601,127 @@ func (p *provisioner) createHabUser(o terraform.UIOutput, comm communicator.Comm
 // In the future we'll remove the dedicated install once the synchronous load feature in hab-sup is
 // available. Until then we install here to provide output and a noisy failure mechanism because
 // if you install with the pkg load, it occurs asynchronously and fails quietly.
-func (p *provisioner) installHabPackage(o terraform.UIOutput, comm communicator.Communicator, service Service) error {
-	var command string
-	options := ""
-	if service.Channel != "" {
-		options += fmt.Sprintf(" --channel %s", service.Channel)
-	}
-
-	if service.URL != "" {
-		options += fmt.Sprintf(" --url %s", service.URL)
-	}
-	if p.UseSudo {
-		command = fmt.Sprintf("env HAB_NONINTERACTIVE=true sudo -E hab pkg install %s %s", service.Name, options)
-	} else {
-		command = fmt.Sprintf("env HAB_NONINTERACTIVE=true hab pkg install %s %s", service.Name, options)
-	}
-
-	if p.BuilderAuthToken != "" {
-		command = fmt.Sprintf("env HAB_AUTH_TOKEN=%s %s", p.BuilderAuthToken, command)
-	}
-	return p.runCommand(o, comm, command)
+func (p *linuxPlatform) installHabPackage(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+	if service.Hart != "" {
+		return p.installHart(ctx, o, comm, service.Hart)
+	}
+
+	cmd := newCommand("hab", "pkg", "install", service.Name).
+		Option("--channel", service.Channel).
+		Option("--url", service.URL).
+		Env("HAB_NONINTERACTIVE", "true").
+		SecretEnv("HAB_AUTH_TOKEN", p.BuilderAuthToken).
+		Sudo(p.UseSudo)
+	return p.retry(ctx, o, stepInstallPackage, p.retryPolicy(service), func() error {
+		return p.run(ctx, o, comm, cmd)
+	})
 }
 
-func (p *provisioner) linuxStartHabService(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
-	var command string
-	var service Service
-	service = params[0].habService
-
-	if err := p.installHabPackage(o, comm, service); err != nil {
+func (p *linuxPlatform) StartHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+	status, loaded := p.serviceStatus(ctx, comm, service)
+	matches := loaded && status.matches(service, p.Organization)
+	if matches {
+		o.Output(fmt.Sprintf("%s is already loaded as %s in %s", service.Name, status.Ident, status.Group))
+	} else if err := p.installHabPackage(ctx, o, comm, service); err != nil {
 		return err
 	}
-	if err := p.linuxUploadUserTOML(o, comm, service); err != nil {
+	if err := p.uploadUserTOML(ctx, o, comm, service); err != nil {
 		return err
 	}
 
 	// Upload service group key
 	if service.ServiceGroupKey != "" {
-		p.uploadServiceGroupKey(o, comm, service.ServiceGroupKey)
+		if err := p.ImportKey(ctx, o, comm, service.ServiceGroupKey); err != nil {
+			return err
+		}
 	}
 
-	options := ""
-	if service.Topology != "" {
-		options += fmt.Sprintf(" --topology %s", service.Topology)
+	if matches && status.upToDate(service, p.Organization) {
+		return nil
 	}
 
-	if service.Strategy != "" {
-		options += fmt.Sprintf(" --strategy %s", service.Strategy)
+	cmd := p.habCtl("svc", "load", service.Name).
+		Args(service.loadOptions()...).
+		SecretEnv("HAB_AUTH_TOKEN", p.BuilderAuthToken)
+	if matches {
+		// Update the options the service was loaded with
+		o.Output(fmt.Sprintf("Reloading %s to apply its changed options", service.Name))
+		cmd.Flag("--force", true)
+	} else if loaded {
+		// Replace the service loaded with another package or group
+		o.Output(fmt.Sprintf("Reloading %s, currently loaded as %s in %s", service.Name, status.Ident, status.Group))
+		cmd.Flag("--force", true)
 	}
+	return p.retry(ctx, o, stepLoadService, p.retryPolicy(service), func() error {
+		return p.run(ctx, o, comm, cmd)
+	})
+}
 
-	if service.Channel != "" {
-		options += fmt.Sprintf(" --channel %s", service.Channel)
+func (p *linuxPlatform) ApplyHabConfig(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service, group string) error {
+	// The applied configuration is kept to detect changes, only readable by root
+	dst := path.Join("/hab/svc", service.Ident.Name, "config-apply.toml")
+	if service.ApplyConfig == "hash" && p.remoteChecksum(ctx, comm, dst) == sha256Hex([]byte(service.UserTOML)) {
+		o.Output("The configuration of service group " + group + " is unchanged")
+		return nil
 	}
 
-	if service.URL != "" {
-		options += fmt.Sprintf(" --url %s", service.URL)
+	if err := p.writeSecretFile(ctx, o, comm, dst, strings.NewReader(service.UserTOML)); err != nil {
+		return fmt.Errorf("Uploading the configuration of %s failed: %v", service.Name, err)
 	}
 
-	if service.Group != "" {
-		options += fmt.Sprintf(" --group %s", service.Group)
+	incarnation := strconv.FormatUint(configIncarnation(), 10)
+	if err := p.run(ctx, o, comm, p.habCtl("config", "apply", group, incarnation, dst)); err != nil {
+		// Don't let the next run take the configuration as applied
+		p.run(ctx, o, comm, newCommand("rm", "-f", dst).Sudo(p.UseSudo))
+		return err
 	}
+	return nil
+}
 
-	for _, bind := range service.Binds {
-		options += fmt.Sprintf(" --bind %s", bind.toBindString())
-	}
-	command = fmt.Sprintf("hab svc load %s %s", service.Name, options)
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo -E %s", command)
+func (p *linuxPlatform) UnloadHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+	return p.run(ctx, o, comm, p.habCtl("svc", "unload", service.Name))
+}
+
+func (p *linuxPlatform) UploadFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content io.Reader) error {
+	if !p.UseSudo {
+		return comm.Upload(dst, content)
 	}
-	if p.BuilderAuthToken != "" {
-		command = fmt.Sprintf("env HAB_AUTH_TOKEN=%s %s", p.BuilderAuthToken, command)
+
+	// Upload to a location we can write to and move the file into place
+	tempPath := path.Join("/tmp", path.Base(dst))
+	if err := comm.Upload(tempPath, content); err != nil {
+		return err
 	}
-	return p.runCommand(o, comm, command)
+	return p.run(ctx, o, comm, newCommand("mv", tempPath, dst).Sudo(true))
 }
 
-func (p *provisioner) uploadServiceGroupKey(o terraform.UIOutput, comm communicator.Communicator, key string) error {
-	keyName := strings.Split(key, "\n")[1]
-	o.Output("Uploading service group key: " + keyName)
-	keyFileName := fmt.Sprintf("%s.box.key", keyName)
-	destPath := path.Join("/hab/cache/keys", keyFileName)
-	keyContent := strings.NewReader(key)
-	if p.UseSudo {
-		tempPath := path.Join("/tmp", keyFileName)
-		if err := comm.Upload(tempPath, keyContent); err != nil {
-			return err
-		}
-		command := fmt.Sprintf("sudo mv %s %s", tempPath, destPath)
-		return p.runCommand(o, comm, command)
+func (p *linuxPlatform) ImportKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, key string) error {
+	name, err := keyName(key)
+	if err != nil {
+		return fmt.Errorf("Invalid service_key: %v", err)
 	}
-
-	return comm.Upload(destPath, keyContent)
+	o.Output("Uploading service group key: " + name)
+	keyFileName := fmt.Sprintf("%s.box.key", name)
+	destPath := path.Join("/hab/cache/keys", keyFileName)
+	return p.writeSecretFile(ctx, o, comm, destPath, strings.NewReader(key))
 }
 
-func (p *provisioner) linuxUploadUserTOML(o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+func (p *linuxPlatform) uploadUserTOML(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
 	// Create the hab svc directory to lay down the user.toml before loading the service
-	o.Output("Uploading user.toml for service: " + service.Name)
-	destDir := fmt.Sprintf("/hab/svc/%s", service.getPackageName(service.Name))
-	command := fmt.Sprintf("mkdir -p %s", destDir)
-	if p.UseSudo {
-		command = fmt.Sprintf("sudo %s", command)
+	destDir := path.Join("/hab/svc", service.Ident.Name)
+	dst := path.Join(destDir, "user.toml")
+	if p.remoteChecksum(ctx, comm, dst) == sha256Hex([]byte(service.UserTOML)) {
+		o.Output("user.toml for service " + service.Name + " is unchanged")
+		return nil
 	}
-	if err := p.runCommand(o, comm, command); err != nil {
+
+	o.Output("Uploading user.toml for service: " + service.Name)
+	if err := p.run(ctx, o, comm, newCommand("mkdir", "-p", destDir).Sudo(p.UseSudo)); err != nil {
 		return err
 	}
 
 	userToml := strings.NewReader(service.UserTOML)
-
-	if p.UseSudo {
-		if err := comm.Upload("/tmp/user.toml", userToml); err != nil {
-			return err
-		}
-		command = fmt.Sprintf("sudo mv /tmp/user.toml %s", destDir)
-		return p.runCommand(o, comm, command)
-	}
-
-	return comm.Upload(path.Join(destDir, "user.toml"), userToml)
-
+	return p.UploadFile(ctx, o, comm, dst, userToml)
 }
 
 func (p *provisioner) copyOutput(o terraform.UIOutput, r io.Reader) {
@@ -364,17 +730,3 @@ func (p *provisioner) copyOutput(o terraform.UIOutput, r io.Reader) {
 		o.Output(line)
 	}
 }
-
-func getBindFromString(bind string) (Bind, error) {
-	t := strings.FieldsFunc(bind, func(d rune) bool {
-		switch d {
-		case ':', '.':
-			return true
-		}
-		return false
-	})
-	if len(t) != 3 {
-		return Bind{}, errors.New("Invalid bind specification: " + bind)
-	}
-	return Bind{Alias: t[0], Service: t[1], Group: t[2]}, nil
-}
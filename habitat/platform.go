@@ -0,0 +1,79 @@
+package habitat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// Platform implements the operating system specific steps needed to install
+// the Habitat supervisor on a target and manage the services it runs.
+//
+// Every capability must either be implemented or return an error created by
+// errNotSupported, so a missing step never passes silently.
+type Platform interface {
+	// InstallHab installs the hab binary on the target.
+	InstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// UploadRingKey uploads and imports the supervisor ring key.
+	UploadRingKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// StartHab installs and starts the Habitat supervisor.
+	StartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// StartHabService installs and loads a service into the supervisor.
+	StartHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error
+
+	// QueryHab requests endpoint from the HTTP gateway of the supervisor and
+	// returns the HTTP status code and body of the response.
+	QueryHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string) (int, string, error)
+
+	// PollHab runs a loop on the target requesting endpoint from the HTTP
+	// gateway until it responds, with status code unless it is 0, or timeout
+	// passes. Rendered scripts wait with it, as they can't be polled.
+	PollHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string, code int, timeout time.Duration) error
+
+	// HabLog returns the last lines of the supervisor log.
+	HabLog(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, lines int) (string, error)
+
+	// DepartHab departs the supervisor from the gossip ring.
+	DepartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// StopHab stops the supervisor and disables its service.
+	StopHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// UninstallHab removes Habitat and all its data from the target.
+	UninstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error
+
+	// ApplyHabConfig applies the user.toml of a service to its service group
+	// with hab config apply, reconfiguring every member of the group.
+	ApplyHabConfig(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service, group string) error
+
+	// UnloadHabService unloads a service from the supervisor.
+	UnloadHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error
+
+	// UploadFile uploads content to dst, using elevated privileges if needed.
+	UploadFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content io.Reader) error
+
+	// ImportKey imports a service group key into the Habitat key cache.
+	ImportKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, key string) error
+}
+
+var (
+	_ Platform = (*linuxPlatform)(nil)
+	_ Platform = (*windowsPlatform)(nil)
+)
+
+// platforms maps an OS type to the constructor of its Platform implementation.
+var platforms = map[string]func(*provisioner) Platform{
+	"linux":   func(p *provisioner) Platform { return &linuxPlatform{p} },
+	"windows": func(p *provisioner) Platform { return &windowsPlatform{p} },
+}
+
+func errNotSupported(capability, osType string) error {
+	return fmt.Errorf("%s is not supported on %s", capability, osType)
+}
@@ -0,0 +1,176 @@
+package habitat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// maxReadyBackoff caps the delay between two readiness polls.
+const maxReadyBackoff = 15 * time.Second
+
+// serviceLogLines is the number of supervisor log lines searched for the
+// output of a service that doesn't become healthy.
+const serviceLogLines = 500
+
+// serviceOutputLines is the number of lines of service output included in the
+// error of a service that doesn't become healthy.
+const serviceOutputLines = 50
+
+// gatewayURL returns the URL of endpoint on the HTTP gateway of the
+// supervisor, as reachable from the target itself.
+func (p *provisioner) gatewayURL(endpoint string) string {
+	host, port := "127.0.0.1", "9631"
+	if h, prt, err := net.SplitHostPort(p.ListenHTTP); err == nil {
+		if h != "" && h != "0.0.0.0" && h != "::" {
+			host = h
+		}
+		port = prt
+	}
+	return "http://" + net.JoinHostPort(host, port) + endpoint
+}
+
+// parseGatewayResponse parses the output of a gateway query, the body of the
+// response followed by the status code on a line of its own.
+func parseGatewayResponse(out string) (int, string, error) {
+	out = strings.TrimRight(out, "\r\n")
+	i := strings.LastIndex(out, "\n")
+	code, err := strconv.Atoi(strings.TrimSpace(out[i+1:]))
+	if err != nil {
+		return 0, "", fmt.Errorf("Error parsing the response of the HTTP gateway: %q", out)
+	}
+	if i < 0 {
+		return code, "", nil
+	}
+	return code, strings.TrimRight(out[:i], "\r"), nil
+}
+
+// poll calls check until it succeeds, doubling the delay between calls. If
+// check doesn't succeed within timeout, or ctx is done, its last error is
+// returned.
+func (p *provisioner) poll(ctx context.Context, timeout time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	backoff := p.ReadyBackoff
+	for {
+		err := check()
+		if err == nil || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		if sleep(ctx, backoff) != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > maxReadyBackoff {
+			backoff = maxReadyBackoff
+		}
+	}
+}
+
+// waitForHab polls the HTTP gateway of the supervisor until it responds.
+func (p *provisioner) waitForHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, platform Platform) error {
+	if p.ReadyTimeout <= 0 {
+		return nil
+	}
+	if p.RenderOnly != "" {
+		// The rendered script waits for the gateway itself
+		return platform.PollHab(ctx, o, comm, "/services", 0, p.ReadyTimeout)
+	}
+
+	o.Output("Waiting for the habitat supervisor to become ready...")
+	err := p.poll(ctx, p.ReadyTimeout, func() error {
+		// Any response means the gateway is up
+		_, _, err := platform.QueryHab(ctx, o, comm, "/services")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("The habitat supervisor did not become ready within %s: %v", p.ReadyTimeout, err)
+	}
+	return nil
+}
+
+// healthCheck is the response of the health endpoint of a service.
+type healthCheck struct {
+	Status string `json:"status"`
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+}
+
+// waitForService polls the health endpoint of a service until it is up and
+// its health check passes. If it doesn't become healthy within its health
+// timeout, the error includes the output of the last health check and the
+// recent output of the service.
+func (p *provisioner) waitForService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, platform Platform, service Service) error {
+	group := service.Group
+	if group == "" {
+		group = "default"
+	}
+	name := service.Ident.Name
+	endpoint := fmt.Sprintf("/services/%s/%s/health", name, group)
+	if p.Organization != "" {
+		// The gateway only serves services of an organization with it
+		endpoint = fmt.Sprintf("/services/%s/%s/%s/health", name, group, p.Organization)
+	}
+	if p.RenderOnly != "" {
+		// The rendered script waits for a passing health check itself
+		return platform.PollHab(ctx, o, comm, endpoint, 200, service.HealthTimeout)
+	}
+
+	o.Output("Waiting for service " + service.Name + " to become healthy...")
+	var health healthCheck
+	err := p.poll(ctx, service.HealthTimeout, func() error {
+		code, body, err := platform.QueryHab(ctx, o, comm, endpoint)
+		if err != nil {
+			return err
+		}
+		if code == 404 {
+			return errors.New("the service is not running yet")
+		}
+
+		health = healthCheck{}
+		if err := json.Unmarshal([]byte(body), &health); err != nil {
+			return fmt.Errorf("unexpected response with status %d: %s", code, body)
+		}
+		if code != 200 || (health.Status != "OK" && health.Status != "WARNING") {
+			return fmt.Errorf("the health check reported %s", health.Status)
+		}
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf("Service %s did not become healthy within %s: %v", service.Name, service.HealthTimeout, err)
+	if out := strings.TrimSpace(health.Stdout + health.Stderr); out != "" {
+		msg += "\n\nHealth check output:\n" + out
+	}
+	if log, logErr := platform.HabLog(ctx, o, comm, serviceLogLines); logErr == nil {
+		if out := serviceOutput(log, name+"."+group); out != "" {
+			msg += "\n\nRecent output of the service:\n" + out
+		}
+	}
+	return errors.New(msg)
+}
+
+// serviceOutput returns the last lines of the supervisor log written by the
+// service group, which are prefixed with "<service>.<group>(".
+func serviceOutput(log, serviceGroup string) string {
+	var lines []string
+	for _, line := range strings.Split(log, "\n") {
+		if strings.Contains(line, serviceGroup+"(") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > serviceOutputLines {
+		lines = lines[len(lines)-serviceOutputLines:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,208 @@
+package habitat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// readinessPlatform is a Platform whose gateway becomes reachable after a
+// number of queries, and then responds with the given status code and body.
+type readinessPlatform struct {
+	Platform
+	readyAfter int
+	code       int
+	body       string
+	endpoints  []string
+}
+
+func (p *readinessPlatform) QueryHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string) (int, string, error) {
+	p.endpoints = append(p.endpoints, endpoint)
+	if p.readyAfter < 0 || len(p.endpoints) <= p.readyAfter {
+		return 0, "", errors.New("connection refused")
+	}
+	return p.code, p.body, nil
+}
+
+func (p *readinessPlatform) PollHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string, code int, timeout time.Duration) error {
+	p.endpoints = append(p.endpoints, fmt.Sprintf("poll %s %d %s", endpoint, code, timeout))
+	return nil
+}
+
+func (p *readinessPlatform) HabLog(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, lines int) (string, error) {
+	return "hab-sup(MR): Unable to bind to 0.0.0.0:9638\n" +
+		"redis.default(O): Can't open the append-only file: Permission denied\n" +
+		"nginx.default(O): started", nil
+}
+
+func TestProvisioner_gatewayURL(t *testing.T) {
+	cases := map[string]string{
+		"":               "http://127.0.0.1:9631/services",
+		"0.0.0.0:9641":   "http://127.0.0.1:9641/services",
+		"10.0.0.5:9631":  "http://10.0.0.5:9631/services",
+		"[::]:9631":      "http://127.0.0.1:9631/services",
+		"[fe80::1]:9631": "http://[fe80::1]:9631/services",
+	}
+
+	for listen, expected := range cases {
+		p := &provisioner{ListenHTTP: listen}
+		if got := p.gatewayURL("/services"); got != expected {
+			t.Errorf("%q: expected %s, got %s", listen, expected, got)
+		}
+	}
+}
+
+func TestProvisioner_waitForHab(t *testing.T) {
+	p := &provisioner{ReadyTimeout: time.Second, ReadyBackoff: time.Millisecond}
+	platform := &readinessPlatform{readyAfter: 3, code: 200}
+
+	if err := p.waitForHab(context.Background(), new(terraform.MockUIOutput), nil, platform); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(platform.endpoints) != 4 {
+		t.Errorf("expected 4 queries, got %d", len(platform.endpoints))
+	}
+}
+
+func TestProvisioner_waitForHab_timeout(t *testing.T) {
+	p := &provisioner{ReadyTimeout: 50 * time.Millisecond, ReadyBackoff: 10 * time.Millisecond}
+	platform := &readinessPlatform{readyAfter: -1}
+	err := p.waitForHab(context.Background(), new(terraform.MockUIOutput), nil, platform)
+	if err == nil || !strings.Contains(err.Error(), "did not become ready") {
+		t.Fatalf("expected a readiness error, got %v", err)
+	}
+}
+
+func TestProvisioner_waitForHab_disabled(t *testing.T) {
+	p := &provisioner{}
+	platform := &readinessPlatform{readyAfter: -1}
+
+	if err := p.waitForHab(context.Background(), new(terraform.MockUIOutput), nil, platform); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(platform.endpoints) != 0 {
+		t.Errorf("expected no queries, got %d", len(platform.endpoints))
+	}
+}
+
+func TestProvisioner_render(t *testing.T) {
+	p := &provisioner{ReadyTimeout: time.Minute, RenderOnly: "provision.sh"}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, HealthTimeout: time.Second}
+	platform := &readinessPlatform{readyAfter: -1}
+
+	// The rendered script polls instead of the provisioner
+	if err := p.waitForHab(context.Background(), new(terraform.MockUIOutput), nil, platform); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.waitForService(context.Background(), new(terraform.MockUIOutput), nil, platform, service); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"poll /services 0 1m0s", "poll /services/redis/default/health 200 1s"}
+	if !reflect.DeepEqual(platform.endpoints, expected) {
+		t.Errorf("expected %v, got %v", expected, platform.endpoints)
+	}
+}
+
+func TestLinuxPollScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	cases := []struct {
+		code     string
+		response string
+		ok       bool
+	}{
+		{"", "printf '[]\\n200'", true},
+		{"", "printf '[]\\n503'", true},
+		{"200", "printf '{}\\n200'", true},
+		{"200", "printf '{}\\n503'", false},
+		{"", "printf '\\n000'; exit 7", false},
+		{"", "exit 4", false},
+	}
+	for _, tc := range cases {
+		// A timeout of 0 seconds gives up after the first request
+		err := exec.Command(sh, "-c", linuxPollScript, "sh", "0", "http://127.0.0.1:9631/services", tc.code, tc.response).Run()
+		if (err == nil) != tc.ok {
+			t.Errorf("%s with code %q: expected success %v, got %v", tc.response, tc.code, tc.ok, err)
+		}
+	}
+}
+
+func TestProvisioner_waitForService(t *testing.T) {
+	p := &provisioner{ReadyBackoff: time.Millisecond}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, Group: "prod", HealthTimeout: time.Second}
+	platform := &readinessPlatform{readyAfter: 1, code: 200, body: `{"status":"OK","stdout":"","stderr":""}`}
+
+	if err := p.waitForService(context.Background(), new(terraform.MockUIOutput), nil, platform, service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if platform.endpoints[1] != "/services/redis/prod/health" {
+		t.Errorf("unexpected endpoint %s", platform.endpoints[1])
+	}
+}
+
+func TestProvisioner_waitForService_organization(t *testing.T) {
+	p := &provisioner{ReadyBackoff: time.Millisecond, Organization: "acme"}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, HealthTimeout: time.Second}
+	platform := &readinessPlatform{code: 200, body: `{"status":"OK","stdout":"","stderr":""}`}
+
+	if err := p.waitForService(context.Background(), new(terraform.MockUIOutput), nil, platform, service); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if platform.endpoints[0] != "/services/redis/default/acme/health" {
+		t.Errorf("unexpected endpoint %s", platform.endpoints[0])
+	}
+}
+
+func TestProvisioner_waitForService_unhealthy(t *testing.T) {
+	p := &provisioner{ReadyBackoff: 10 * time.Millisecond}
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}, HealthTimeout: 50 * time.Millisecond}
+	platform := &readinessPlatform{code: 503, body: `{"status":"CRITICAL","stdout":"redis-cli: connection refused","stderr":""}`}
+
+	err := p.waitForService(context.Background(), new(terraform.MockUIOutput), nil, platform, service)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"reported CRITICAL", "redis-cli: connection refused", "append-only file"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in the error, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "nginx") {
+		t.Errorf("unexpected output of another service in the error: %v", err)
+	}
+}
+
+func TestParseGatewayResponse(t *testing.T) {
+	cases := []struct {
+		out  string
+		code int
+		body string
+	}{
+		{"{\"status\":\"OK\"}\n200", 200, `{"status":"OK"}`},
+		{"{\"status\":\"OK\"}\r\n200\r\n", 200, `{"status":"OK"}`},
+		{"\n404", 404, ""},
+		{"503", 503, ""},
+	}
+
+	for _, tc := range cases {
+		code, body, err := parseGatewayResponse(tc.out)
+		if err != nil || code != tc.code || body != tc.body {
+			t.Errorf("%q: expected %d %q, got %d %q %v", tc.out, tc.code, tc.body, code, body, err)
+		}
+	}
+
+	if _, _, err := parseGatewayResponse("not a response"); err == nil {
+		t.Error("expected an error for an invalid response")
+	}
+}
@@ -0,0 +1,150 @@
+package habitat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// errRendering is returned by probes when rendering, as there is no target to
+// inspect. Every step is rendered as if the target was fresh.
+var errRendering = errors.New("The target is not inspected when rendering")
+
+// scriptCommunicator records the commands and uploads of a provisioning run
+// as a standalone script, instead of running them on a target.
+type scriptCommunicator struct {
+	osType string
+	script bytes.Buffer
+}
+
+func newScriptCommunicator(osType string) *scriptCommunicator {
+	c := &scriptCommunicator{osType: osType}
+	if osType == "windows" {
+		c.script.WriteString("# Provisioning steps rendered by the habitat provisioner. Secrets are masked.\n")
+		c.script.WriteString("$ErrorActionPreference = 'Stop'\n")
+	} else {
+		c.script.WriteString("#!/bin/sh\n")
+		c.script.WriteString("# Provisioning steps rendered by the habitat provisioner. Secrets are masked.\n")
+		c.script.WriteString("set -e\n")
+	}
+	return c
+}
+
+func (c *scriptCommunicator) Connect(terraform.UIOutput) error {
+	return nil
+}
+
+func (c *scriptCommunicator) Disconnect() error {
+	return nil
+}
+
+func (c *scriptCommunicator) Timeout() time.Duration {
+	return 0
+}
+
+func (c *scriptCommunicator) ScriptPath() string {
+	if c.osType == "windows" {
+		return "C:/Windows/Temp/terraform_habitat.cmd"
+	}
+	return "/tmp/terraform_habitat.sh"
+}
+
+func (c *scriptCommunicator) Start(cmd *remote.Cmd) error {
+	var stdin []byte
+	if cmd.Stdin != nil {
+		var err error
+		if stdin, err = ioutil.ReadAll(cmd.Stdin); err != nil {
+			return err
+		}
+	}
+
+	c.script.WriteString("\n")
+	if c.osType == "windows" {
+		if _, script, ok := decodePowerShell(cmd.Command); ok {
+			fmt.Fprintf(&c.script, "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command {\n%s\n}\n", strings.Trim(script, "\n"))
+		} else {
+			fmt.Fprintln(&c.script, cmd.Command)
+		}
+		c.script.WriteString("if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\n")
+	} else {
+		if stdin != nil {
+			fmt.Fprintf(&c.script, "printf %%s %s | ", shellQuote(string(stdin)))
+		}
+		fmt.Fprintln(&c.script, strings.TrimSpace(cmd.Command))
+	}
+
+	cmd.Init()
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func (c *scriptCommunicator) Upload(dst string, content io.Reader) error {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	c.script.WriteString("\n")
+	if !utf8.Valid(b) || bytes.IndexByte(b, 0) >= 0 {
+		// Binaries like hab archives and .hart files can't be embedded
+		fmt.Fprintf(&c.script, "# Copy the local file of %d bytes uploaded to %s to the target before running this script\n", len(b), dst)
+		return nil
+	}
+	if c.osType == "windows" {
+		fmt.Fprintf(&c.script, "[IO.File]::WriteAllText(%s, %s)\n", psQuote(dst), psQuote(string(b)))
+	} else {
+		fmt.Fprintf(&c.script, "printf %%s %s > %s\n", shellQuote(string(b)), shellQuote(dst))
+	}
+	return nil
+}
+
+func (c *scriptCommunicator) UploadScript(dst string, content io.Reader) error {
+	if err := c.Upload(dst, content); err != nil {
+		return err
+	}
+	if c.osType != "windows" {
+		fmt.Fprintf(&c.script, "chmod 0777 %s\n", shellQuote(path.Clean(dst)))
+	}
+	return nil
+}
+
+func (c *scriptCommunicator) UploadDir(dst, src string) error {
+	return errors.New("Uploading directories is not supported when rendering")
+}
+
+// String returns the rendered script.
+func (c *scriptCommunicator) String() string {
+	return c.script.String()
+}
+
+// render writes the steps of the provisioner to the local script RenderOnly,
+// instead of running them on the target.
+func (p *provisioner) render(ctx context.Context, o terraform.UIOutput, newPlatform func(*provisioner) Platform) error {
+	comm := newScriptCommunicator(p.OSType)
+
+	var err error
+	if p.Destroy {
+		err = p.destroy(ctx, o, comm, newPlatform)
+	} else {
+		err = p.provision(ctx, o, comm, newPlatform)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(p.RenderOnly, []byte(p.secrets.Mask(comm.String())), 0755); err != nil {
+		return fmt.Errorf("Error writing %s: %v", p.RenderOnly, err)
+	}
+	o.Output("Rendered the provisioning steps to " + p.RenderOnly)
+	return nil
+}
@@ -1,28 +1,41 @@
 package habitat
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	version "github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform/communicator"
 	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/config/hcl2shim"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 )
 
-var serviceTypes = map[string]bool{"unmanaged": true, "systemd": true}
+var serviceTypes = map[string]bool{"unmanaged": true, "systemd": true, "openrc": true, "sysvinit": true, "runit": true, "upstart": true, "auto": true}
 var updateStrategies = map[string]bool{"at-once": true, "rolling": true, "none": true}
 var topologies = map[string]bool{"leader": true, "standalone": true}
+var sha256Checksum = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+$`)
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+var limitPattern = regexp.MustCompile(`^(infinity|[0-9]+)(:(infinity|[0-9]+))?$`)
+var timeSpanPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(us|ms|s|sec|m|min|h)?$`)
+var unitKeyPattern = regexp.MustCompile(`^(Unit|Service|Install)\.[A-Za-z][A-Za-z0-9]*$`)
 
-type provisionFn func(terraform.UIOutput, communicator.Communicator, ...Params) error
-type Params struct {
-	habService Service
-}
+// newCommunicator is replaced in tests.
+var newCommunicator = communicator.New
 
 type provisioner struct {
 	Version          string
@@ -30,6 +43,7 @@ type provisioner struct {
 	PermanentPeer    bool
 	ListenGossip     string
 	ListenHTTP       string
+	ListenCtl        string
 	Peer             string
 	RingKey          string
 	RingKeyContent   string
@@ -46,33 +60,91 @@ type provisioner struct {
 	BuilderAuthToken string
 	SupOptions       string
 	OSType           string
+	Offline          *Offline
+	InstallScriptURL string
+	InstallChecksum  string
+	Destroy          bool
+	RemoveHab        bool
+	Supervisors      []Supervisor
+	SystemdUnit      *SystemdUnit
+	ReadyTimeout     time.Duration
+	ReadyBackoff     time.Duration
+	InstallTimeout   time.Duration
+	StartTimeout     time.Duration
+	LoadTimeout      time.Duration
+	RenderOnly       string
+	DiagnosticLines  int
+	Retry            RetryPolicy
+
+	secrets secrets
+	// habUpdated is set when an installed Habitat was updated, so a running
+	// supervisor is restarted with the new version.
+	habUpdated bool
+}
+
+// SystemdUnit holds additional settings of the systemd unit of the supervisor.
+type SystemdUnit struct {
+	Environment map[string]string
+	LimitNOFILE string
+	User        string
+	Group       string
+	After       []string
+	Wants       []string
+	RestartSec  string
+	// Overrides are written to a drop-in, keyed by "Section.Key"
+	Overrides map[string]string
+}
+
+// Supervisor is an additional supervisor running next to the one configured at
+// the top level, for example to join another ring.
+type Supervisor struct {
+	OverrideName  string
+	ServiceName   string
+	ListenGossip  string
+	ListenHTTP    string
+	ListenCtl     string
+	Peer          string
+	PermanentPeer bool
+	RingKey       string
+}
 
-	installHab      provisionFn
-	uploadRingKey   provisionFn
-	startHab        provisionFn
-	startHabService provisionFn
-	StartHabService provisionFn
+// Offline holds the local artifacts used to install Habitat on targets
+// without network access.
+type Offline struct {
+	HabArchive     string
+	HabSup         string
+	HabLauncher    string
+	Busybox        string
+	WindowsService string
 }
+
 type Service struct {
 	Name            string
+	Ident           PackageIdent
 	Strategy        string
 	Topology        string
 	Channel         string
 	Group           string
 	URL             string
 	Binds           []Bind
-	BindStrings     []string
 	UserTOML        string
 	AppName         string
 	Environment     string
 	OverrideName    string
 	ServiceGroupKey string
+	Hart            string
+	Supervisor      string
+	WaitForHealth   bool
+	HealthTimeout   time.Duration
+	Retry           *RetryPolicy
+	ApplyConfig     string
 }
 
 type Bind struct {
 	Alias   string
 	Service string
 	Group   string
+	Org     string
 }
 
 func Provisioner() *schema.Provisioner {
@@ -118,6 +190,10 @@ func Provisioner() *schema.Provisioner {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			"listen_ctl": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"ring_key": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -150,6 +226,178 @@ func Provisioner() *schema.Provisioner {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			"destroy": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"remove_hab": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"ready_timeout": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "2m",
+			},
+			"ready_backoff": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "1s",
+			},
+			"render_only": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"install_timeout": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "30m",
+			},
+			"start_timeout": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "10m",
+			},
+			"load_timeout": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "15m",
+			},
+			"retry": retrySchema(),
+			"diagnostic_lines": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  50,
+			},
+			"systemd_unit": &schema.Schema{
+				Type:     schema.TypeList,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"environment": &schema.Schema{
+							Type:     schema.TypeMap,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+							Optional: true,
+						},
+						"limit_nofile": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"user": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"group": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"after": &schema.Schema{
+							Type:     schema.TypeList,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+							Optional: true,
+						},
+						"wants": &schema.Schema{
+							Type:     schema.TypeList,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+							Optional: true,
+						},
+						"restart_sec": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"overrides": &schema.Schema{
+							Type:     schema.TypeMap,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+							Optional: true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"os_type": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"install_script_url": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"install_checksum": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"offline": &schema.Schema{
+				Type:     schema.TypeList,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"hab_archive": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"hab_sup": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"hab_launcher": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"busybox": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"windows_service": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"supervisor": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"override_name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"service_name": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"listen_gossip": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"listen_http": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"listen_ctl": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"peer": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"permanent_peer": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"ring_key": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+				Optional: true,
+			},
 			"service": &schema.Schema{
 				Type: schema.TypeSet,
 				Elem: &schema.Resource{
@@ -179,6 +427,10 @@ func Provisioner() *schema.Provisioner {
 										Type:     schema.TypeString,
 										Required: true,
 									},
+									"org": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
 								},
 							},
 							Optional: true,
@@ -191,6 +443,22 @@ func Provisioner() *schema.Provisioner {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
+						"default_toml": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"config": &schema.Schema{
+							Type:     schema.TypeMap,
+							Optional: true,
+						},
+						"config_types": &schema.Schema{
+							Type:     schema.TypeMap,
+							Optional: true,
+						},
+						"apply_config": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
 						"strategy": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
@@ -224,6 +492,25 @@ func Provisioner() *schema.Provisioner {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
+						"hart": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"supervisor": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"retry": retrySchema(),
+						"wait_for_health": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"health_timeout": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "5m",
+						},
 					},
 				},
 				Optional: true,
@@ -234,7 +521,41 @@ func Provisioner() *schema.Provisioner {
 	}
 }
 
-func applyFn(ctx context.Context) error {
+// retrySchema returns the schema of a retry block, which is used both for
+// the provisioner and for a single service.
+func retrySchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"attempts": &schema.Schema{
+					Type:     schema.TypeInt,
+					Optional: true,
+					Default:  defaultRetryPolicy.Attempts,
+				},
+				"initial_delay": &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+					Default:  defaultRetryPolicy.InitialDelay.String(),
+				},
+				"max_delay": &schema.Schema{
+					Type:     schema.TypeString,
+					Optional: true,
+					Default:  defaultRetryPolicy.MaxDelay.String(),
+				},
+				"jitter": &schema.Schema{
+					Type:     schema.TypeFloat,
+					Optional: true,
+					Default:  defaultRetryPolicy.Jitter,
+				},
+			},
+		},
+		Optional: true,
+	}
+}
+
+func applyFn(ctx context.Context) (err error) {
 	o := ctx.Value(schema.ProvOutputKey).(terraform.UIOutput)
 	s := ctx.Value(schema.ProvRawStateKey).(*terraform.InstanceState)
 	d := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
@@ -244,6 +565,12 @@ func applyFn(ctx context.Context) error {
 		return err
 	}
 
+	// Make sure secrets never end up in the output or returned errors
+	o = &maskedOutput{UIOutput: o, secrets: &p.secrets}
+	defer func() {
+		err = p.secrets.Error(err)
+	}()
+
 	if p.OSType == "" {
 		switch t := s.Ephemeral.ConnInfo["type"]; t {
 		case "ssh", "": // The default connection type is ssh, so if the type is empty assume ssh
@@ -255,24 +582,15 @@ func applyFn(ctx context.Context) error {
 		}
 	}
 
-	// Set some values based on the targeted OS
-	switch p.OSType {
-	case "linux":
-		p.installHab = p.linuxInstallHab
-		p.uploadRingKey = p.linuxUploadRingKey
-		p.startHab = p.linuxStartHab
-		p.startHabService = p.linuxStartHabService
-
-	case "windows":
-		p.installHab = p.winInstallHab
-		p.startHabService = p.winStartHabService
-		p.startHab = p.winStartHab
-
-	default:
+	newPlatform, ok := platforms[p.OSType]
+	if !ok {
 		return fmt.Errorf("Unsupported os type: %s", p.OSType)
 	}
+	if p.RenderOnly != "" {
+		return p.render(ctx, o, newPlatform)
+	}
 
-	comm, err := communicator.New(s)
+	comm, err := newCommunicator(s)
 	if err != nil {
 		return err
 	}
@@ -289,34 +607,103 @@ func applyFn(ctx context.Context) error {
 	}
 	defer comm.Disconnect()
 
+	if p.Destroy {
+		err = p.destroy(ctx, o, comm, newPlatform)
+	} else {
+		err = p.provision(ctx, o, comm, newPlatform)
+	}
+	if err != nil {
+		p.diagnose(ctx, o, comm, newPlatform)
+	}
+	return err
+}
+
+// provision installs Habitat, starts the supervisors and loads the services.
+func (p *provisioner) provision(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, newPlatform func(*provisioner) Platform) error {
+	platform := newPlatform(p)
 	if !p.SkipInstall {
 		o.Output("Installing habitat...")
-		if err := p.installHab(o, comm); err != nil {
+		err := withTimeout(ctx, p.InstallTimeout, "Installing habitat", func(ctx context.Context) error {
+			return platform.InstallHab(ctx, o, comm)
+		})
+		if err != nil {
 			o.Output("Error installing habitat...")
 			return err
 		}
 	}
 
-	if p.OSType != "windows" { //ToDo: remove this after adding similar for Win
+	if p.RingKeyContent != "" {
+		o.Output("Uploading supervisor ring key...")
+		if err := platform.UploadRingKey(ctx, o, comm); err != nil {
+			return err
+		}
+	}
 
-		if p.RingKeyContent != "" {
-			o.Output("Uploading supervisor ring key...")
-			if err := p.uploadRingKey(o, comm); err != nil {
+	for _, sup := range p.supervisors() {
+		platform := newPlatform(sup)
+		o.Output("Starting the habitat supervisor: " + sup.supName())
+		err := withTimeout(ctx, p.StartTimeout, "Starting the habitat supervisor "+sup.supName(), func(ctx context.Context) error {
+			return platform.StartHab(ctx, o, comm)
+		})
+		if err != nil {
+			return err
+		}
+		if err := sup.waitForHab(ctx, o, comm, platform); err != nil {
+			return err
+		}
+		for _, service := range sup.Services {
+			o.Output("Starting service: " + service.Name)
+			err := withTimeout(ctx, p.LoadTimeout, "Starting service "+service.Name, func(ctx context.Context) error {
+				return platform.StartHabService(ctx, o, comm, service)
+			})
+			if err != nil {
 				return err
 			}
+			if service.ApplyConfig != "" {
+				group := service.serviceGroup(sup.Organization)
+				o.Output("Applying the configuration of service group: " + group)
+				if err := platform.ApplyHabConfig(ctx, o, comm, service, group); err != nil {
+					return err
+				}
+			}
+			if service.WaitForHealth {
+				if err := sup.waitForService(ctx, o, comm, platform, service); err != nil {
+					return err
+				}
+			}
 		}
 	}
-	o.Output("Starting the habitat supervisor...")
-	if err := p.startHab(o, comm); err != nil {
-		return err
-	}
-	if p.Services != nil {
-		for _, service := range p.Services {
-			o.Output("Starting service: " + service.Name)
-			if err := p.startHabService(o, comm, Params{habService: service}); err != nil {
+	return nil
+}
+
+// destroy unloads the services, removes the supervisor from the ring and
+// stops it, for use in a provisioner with when = "destroy".
+func (p *provisioner) destroy(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, newPlatform func(*provisioner) Platform) error {
+	for _, sup := range p.supervisors() {
+		platform := newPlatform(sup)
+		for _, service := range sup.Services {
+			o.Output("Unloading service: " + service.Name)
+			if err := platform.UnloadHabService(ctx, o, comm, service); err != nil {
 				return err
 			}
 		}
+
+		o.Output("Departing the habitat supervisor from the ring: " + sup.supName())
+		if err := platform.DepartHab(ctx, o, comm); err != nil {
+			return err
+		}
+
+		o.Output("Stopping the habitat supervisor: " + sup.supName())
+		if err := platform.StopHab(ctx, o, comm); err != nil {
+			return err
+		}
+	}
+
+	if p.RemoveHab {
+		o.Output("Removing habitat...")
+		if err := newPlatform(p).UninstallHab(ctx, o, comm); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -327,6 +714,39 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 		if !serviceTypes[serviceType.(string)] {
 			es = append(es, errors.New(serviceType.(string)+" is not a valid service_type."))
 		}
+		if _, render := c.Get("render_only"); render && serviceType == "auto" {
+			es = append(es, errors.New("service_type auto can't be used with render_only, as the init system isn't detected when rendering."))
+		}
+	}
+
+	for _, key := range []string{"ready_timeout", "ready_backoff", "install_timeout", "start_timeout", "load_timeout"} {
+		if v, ok := c.Get(key); ok {
+			if d, err := time.ParseDuration(v.(string)); err != nil || d < 0 || (key == "ready_backoff" && d == 0) {
+				es = append(es, errors.New(v.(string)+" is not a valid "+key+"."))
+			}
+		}
+	}
+
+	if retry, ok := c.Get("retry"); ok {
+		retryList, _ := retry.([]map[string]interface{})
+		for _, r := range retryList {
+			es = append(es, validateRetry(r)...)
+		}
+	}
+
+	if lines, ok := c.Get("diagnostic_lines"); ok {
+		if n, ok := lines.(int); ok && n < 0 {
+			es = append(es, errors.New("diagnostic_lines must not be negative."))
+		}
+	}
+
+	if unit, ok := c.Get("systemd_unit"); ok {
+		if serviceType != nil && serviceType != "systemd" && serviceType != "auto" {
+			ws = append(ws, "systemd_unit is only used with the systemd service_type.")
+		}
+		for _, u := range unit.([]map[string]interface{}) {
+			es = append(es, validateSystemdUnit(u)...)
+		}
 	}
 
 	builderURL, ok := c.Get("url")
@@ -336,6 +756,55 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 		}
 	}
 
+	destroy, _ := c.Get("destroy")
+	removeHab, _ := c.Get("remove_hab")
+	if removeHab == true && destroy != true {
+		ws = append(ws, "remove_hab is only used when destroy is set.")
+	}
+
+	osType, ok := c.Get("os_type")
+	if ok {
+		if _, ok := platforms[osType.(string)]; !ok {
+			es = append(es, errors.New(osType.(string)+" is not a valid os_type."))
+		}
+	}
+
+	installURL, ok := c.Get("install_script_url")
+	if ok {
+		if _, err := url.ParseRequestURI(installURL.(string)); err != nil {
+			es = append(es, errors.New(installURL.(string)+" is not a valid URL."))
+		}
+	}
+
+	checksum, ok := c.Get("install_checksum")
+	if ok && !sha256Checksum.MatchString(checksum.(string)) {
+		es = append(es, errors.New(checksum.(string)+" is not a valid SHA-256 checksum."))
+	}
+
+	// The target OS is only known at plan time when os_type is set, otherwise
+	// it is detected from the connection and the platform checks it on apply.
+	if offline, ok := c.Get("offline"); ok {
+		for _, o := range offline.([]map[string]interface{}) {
+			busybox, _ := o["busybox"].(string)
+			windowsService, _ := o["windows_service"].(string)
+			switch {
+			case osType == "linux" && busybox == "":
+				es = append(es, errors.New("offline.busybox is required to install Habitat on linux."))
+			case osType == "windows" && windowsService == "":
+				es = append(es, errors.New("offline.windows_service is required to install Habitat on windows."))
+			case osType == nil && busybox == "" && windowsService == "":
+				es = append(es, errors.New("offline requires busybox for linux targets or windows_service for windows targets."))
+			}
+		}
+	}
+
+	ringKey, ok := c.Get("ring_key_content")
+	if ok && !isUnknown(ringKey.(string)) {
+		if _, err := keyName(ringKey.(string)); err != nil {
+			es = append(es, fmt.Errorf("ring_key_content is not a valid key: %v.", err))
+		}
+	}
+
 	v, ok := c.Get("version")
 	if ok && v != nil && strings.TrimSpace(v.(string)) != "" {
 		if _, err := version.NewVersion(v.(string)); err != nil {
@@ -343,6 +812,22 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 		}
 	}
 
+	// Warn when the selected install source cannot provide the requested version
+	if v != nil && strings.TrimSpace(v.(string)) != "" {
+		osType, _ := c.Get("os_type")
+		if _, ok := c.Get("offline"); ok {
+			ws = append(ws, "version is ignored when installing from offline files, the supplied archive determines the installed version.")
+		} else if _, ok := c.Get("install_script_url"); ok && osType == "windows" {
+			ws = append(ws, "install_script_url may not provide version "+v.(string)+", on Windows targets the downloaded archive determines the installed version.")
+		} else if osType == "windows" {
+			versionRequired, _ := version.NewVersion(v.(string))
+			versionMin, _ := version.NewVersion(winMinVersion)
+			if versionRequired != nil && versionRequired.LessThan(versionMin) {
+				ws = append(ws, "version "+v.(string)+" cannot be installed on Windows targets, the oldest version available for Windows is "+winMinVersion+".")
+			}
+		}
+	}
+
 	acceptLicense, ok := c.Get("accept_license")
 	if ok && !acceptLicense.(bool) {
 		if v != nil && strings.TrimSpace(v.(string)) != "" {
@@ -356,10 +841,152 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 		}
 	}
 
+	// Validate additional supervisors, they must not share names or ports with
+	// each other or the top level supervisor
+	supNames := map[string]bool{"default": true}
+	if name, ok := c.Get("override_name"); ok {
+		supNames = map[string]bool{name.(string): true}
+	}
+	serviceName, ok := c.Get("service_name")
+	if !ok {
+		serviceName = "hab-supervisor"
+	}
+	if !serviceNamePattern.MatchString(serviceName.(string)) {
+		es = append(es, errors.New(serviceName.(string)+" is not a valid service_name."))
+	}
+	serviceNames := map[string]bool{serviceName.(string): true}
+	ports := map[string]bool{}
+	for key, port := range map[string]string{"listen_gossip": "9638", "listen_http": "9631", "listen_ctl": "9632"} {
+		if listen, ok := c.Get(key); ok {
+			port = listenPort(listen.(string))
+		}
+		ports[port] = true
+	}
+
+	supervisors, _ := c.Get("supervisor")
+	supervisorList, _ := supervisors.([]map[string]interface{})
+	for _, sup := range supervisorList {
+		name, _ := sup["override_name"].(string)
+		if supNames[name] {
+			es = append(es, errors.New("supervisor "+name+" is configured more than once."))
+		}
+		supNames[name] = true
+
+		serviceName, ok := sup["service_name"].(string)
+		if !ok {
+			serviceName = "hab-sup-" + name
+		}
+		if !serviceNamePattern.MatchString(serviceName) {
+			es = append(es, errors.New(serviceName+" is not a valid service_name."))
+		} else if serviceNames[serviceName] {
+			es = append(es, errors.New("service_name "+serviceName+" of supervisor "+name+" is already in use."))
+		}
+		serviceNames[serviceName] = true
+
+		for _, key := range []string{"listen_gossip", "listen_http", "listen_ctl"} {
+			listen, ok := sup[key].(string)
+			if !ok {
+				continue
+			}
+			if port := listenPort(listen); ports[port] {
+				es = append(es, errors.New(key+" "+listen+" of supervisor "+name+" uses a port that is already in use."))
+			} else {
+				ports[port] = true
+			}
+		}
+	}
+
 	// Validate service level configs
 	services, ok := c.Get("service")
 	if ok {
 		for _, service := range services.([]map[string]interface{}) {
+			name, ok := service["name"].(string)
+			if ok {
+				if _, err := parsePackageIdent(name); err != nil {
+					es = append(es, err)
+				}
+			}
+
+			if key, ok := service["service_key"].(string); ok && !isUnknown(key) {
+				if _, err := keyName(key); err != nil {
+					es = append(es, fmt.Errorf("service_key of service %s is not a valid key: %v.", name, err))
+				}
+			}
+
+			userTOML, hasUserTOML := service["user_toml"].(string)
+			if config, ok := service["config"].(map[string]interface{}); ok && len(config) > 0 {
+				if hasUserTOML {
+					es = append(es, errors.New("Service "+name+": config and user_toml can't be used together."))
+				}
+				values := make(map[string]string, len(config))
+				for key, value := range config {
+					values[key] = fmt.Sprint(value)
+				}
+				types := make(map[string]string)
+				if configTypes, ok := service["config_types"].(map[string]interface{}); ok {
+					for key, typ := range configTypes {
+						// Values only known on apply can't be checked yet
+						if !isUnknown(values[key]) {
+							types[key] = fmt.Sprint(typ)
+						}
+					}
+				}
+				var err error
+				if userTOML, err = configTOML(values, types); err != nil {
+					es = append(es, fmt.Errorf("Service %s: %v.", name, err))
+				} else {
+					hasUserTOML = true
+				}
+			}
+			if applyConfig, ok := service["apply_config"].(string); ok {
+				if !applyConfigModes[applyConfig] {
+					es = append(es, errors.New(applyConfig+" is not a valid apply_config."))
+				}
+				if !hasUserTOML {
+					es = append(es, errors.New("Service "+name+": apply_config requires user_toml or config."))
+				}
+			}
+			// user_toml is often rendered from a template only known on apply
+			if hasUserTOML && !isUnknown(userTOML) {
+				defaultTOML, _ := service["default_toml"].(string)
+				if isUnknown(defaultTOML) {
+					defaultTOML = ""
+				}
+				w, e := validateUserTOML(name, userTOML, defaultTOML)
+				ws = append(ws, w...)
+				es = append(es, e...)
+			}
+
+			if binds, ok := service["binds"].([]interface{}); ok {
+				for _, b := range binds {
+					if b, ok := b.(string); ok && !isUnknown(b) {
+						if _, err := getBindFromString(b); err != nil {
+							es = append(es, fmt.Errorf("Service %s: %v", name, err))
+						}
+					}
+				}
+			}
+			if binds, ok := service["bind"].([]map[string]interface{}); ok {
+				for _, b := range binds {
+					bind := Bind{}
+					bind.Alias, _ = b["alias"].(string)
+					bind.Service, _ = b["service"].(string)
+					bind.Group, _ = b["group"].(string)
+					bind.Org, _ = b["org"].(string)
+					if isUnknown(bind.toBindString()) {
+						continue
+					}
+					if err := bind.validate(); err != nil {
+						es = append(es, fmt.Errorf("Service %s: bind %s is not valid: %v.", name, bind.toBindString(), err))
+					}
+				}
+			}
+
+			sup, ok := service["supervisor"].(string)
+			if ok && !supNames[sup] {
+				es = append(es, errors.New(sup+" is not a configured supervisor."))
+			}
+
 			strategy, ok := service["strategy"].(string)
 			if ok && !updateStrategies[strategy] {
 				es = append(es, errors.New(strategy+" is not a valid update strategy."))
@@ -370,6 +997,19 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 				es = append(es, errors.New(topology+" is not a valid topology"))
 			}
 
+			if retry, ok := service["retry"].([]map[string]interface{}); ok {
+				for _, r := range retry {
+					es = append(es, validateRetry(r)...)
+				}
+			}
+
+			healthTimeout, ok := service["health_timeout"].(string)
+			if ok {
+				if d, err := time.ParseDuration(healthTimeout); err != nil || d <= 0 {
+					es = append(es, errors.New(healthTimeout+" is not a valid health_timeout."))
+				}
+			}
+
 			builderURL, ok := service["url"].(string)
 			if ok {
 				if _, err := url.ParseRequestURI(builderURL); err != nil {
@@ -381,29 +1021,154 @@ func validateFn(c *terraform.ResourceConfig) (ws []string, es []error) {
 	return ws, es
 }
 
-func (p *provisioner) runCommand(o terraform.UIOutput, comm communicator.Communicator, command string) error {
-	outR, outW := io.Pipe()
-	errR, errW := io.Pipe()
+// isUnknown reports whether v contains a value that is only known after apply.
+func isUnknown(v string) bool {
+	return strings.Contains(v, hcl2shim.UnknownVariableValue)
+}
+
+// validateRetry validates the settings of a retry block.
+func validateRetry(retry map[string]interface{}) (es []error) {
+	if attempts, ok := retry["attempts"].(int); ok && attempts < 1 {
+		es = append(es, errors.New("retry attempts must be at least 1."))
+	}
+	for _, key := range []string{"initial_delay", "max_delay"} {
+		if v, ok := retry[key].(string); ok {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				es = append(es, errors.New(v+" is not a valid retry "+key+"."))
+			}
+		}
+	}
+	if jitter, ok := retry["jitter"].(float64); ok && (jitter < 0 || jitter > 1) {
+		es = append(es, errors.New("retry jitter must be between 0 and 1."))
+	}
+	return es
+}
+
+// validateSystemdUnit validates the settings of a systemd_unit block.
+func validateSystemdUnit(unit map[string]interface{}) (es []error) {
+	if env, ok := unit["environment"].(map[string]interface{}); ok {
+		for name, value := range env {
+			if !envNamePattern.MatchString(name) {
+				es = append(es, errors.New(name+" is not a valid environment variable name."))
+			}
+			if v, ok := value.(string); ok && strings.Contains(v, "\n") {
+				es = append(es, errors.New("environment variable "+name+" must not contain a newline."))
+			}
+		}
+	}
 
+	if limit, ok := unit["limit_nofile"].(string); ok && !limitPattern.MatchString(limit) {
+		es = append(es, errors.New(limit+" is not a valid limit_nofile."))
+	}
+	if restartSec, ok := unit["restart_sec"].(string); ok && !timeSpanPattern.MatchString(restartSec) {
+		es = append(es, errors.New(restartSec+" is not a valid restart_sec."))
+	}
+	for _, key := range []string{"user", "group"} {
+		if name, ok := unit[key].(string); ok && !userNamePattern.MatchString(name) {
+			es = append(es, errors.New(name+" is not a valid "+key+"."))
+		}
+	}
+	for _, key := range []string{"after", "wants"} {
+		units, _ := unit[key].([]interface{})
+		for _, u := range units {
+			if name, ok := u.(string); ok && !unitNamePattern.MatchString(name) {
+				es = append(es, errors.New(name+" is not a valid unit name in "+key+"."))
+			}
+		}
+	}
+
+	if overrides, ok := unit["overrides"].(map[string]interface{}); ok {
+		for key, value := range overrides {
+			if !unitKeyPattern.MatchString(key) {
+				es = append(es, errors.New(key+" is not a valid override, use Section.Key with the Unit, Service or Install section."))
+			}
+			if v, ok := value.(string); ok && strings.Contains(v, "\n") {
+				es = append(es, errors.New("override "+key+" must not contain a newline."))
+			}
+		}
+	}
+	return es
+}
+
+func (p *provisioner) runCommand(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, command string, stdin io.Reader) error {
+	outR, outW := io.Pipe()
 	go p.copyOutput(o, outR)
-	go p.copyOutput(o, errR)
 	defer outW.Close()
+
+	return p.execute(ctx, o, comm, command, stdin, outW)
+}
+
+// outputCommand runs a command like runCommand, but returns its standard
+// output instead of writing it to the UI.
+func (p *provisioner) outputCommand(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, command string, stdin io.Reader) (string, error) {
+	var stdout bytes.Buffer
+	err := p.execute(ctx, o, comm, command, stdin, &stdout)
+	return stdout.String(), err
+}
+
+// probeCommand runs a command that inspects the state of the target and
+// returns its standard output. A failing probe is expected, so its standard
+// error is discarded. When rendering, probes fail without running.
+func (p *provisioner) probeCommand(ctx context.Context, comm communicator.Communicator, command string) (string, error) {
+	if p.RenderOnly != "" {
+		return "", errRendering
+	}
+
+	var stdout bytes.Buffer
+	cmd := &remote.Cmd{
+		Command: command,
+		Stdout:  &stdout,
+		Stderr:  ioutil.Discard,
+	}
+
+	err := cancelable(ctx, func() error {
+		if err := comm.Start(cmd); err != nil {
+			return err
+		}
+		return cmd.Wait()
+	})
+	return stdout.String(), err
+}
+
+func (p *provisioner) execute(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, command string, stdin io.Reader, stdout io.Writer) error {
+	errR, errW := io.Pipe()
+	go p.copyOutput(o, errR)
 	defer errW.Close()
 
 	cmd := &remote.Cmd{
 		Command: command,
-		Stdout:  outW,
+		Stdin:   stdin,
+		Stdout:  stdout,
 		Stderr:  errW,
 	}
 
-	if err := comm.Start(cmd); err != nil {
-		return fmt.Errorf("Error executing command %q: %v", cmd.Command, err)
+	err := cancelable(ctx, func() error {
+		if err := comm.Start(cmd); err != nil {
+			return fmt.Errorf("Error executing command %q: %v", cmd.Command, err)
+		}
+		return cmd.Wait()
+	})
+	if err != nil && err == ctx.Err() {
+		err = fmt.Errorf("Error executing command %q: %v", cmd.Command, err)
 	}
+	return p.secrets.Error(err)
+}
 
-	if err := cmd.Wait(); err != nil {
-		return err
+// uploadLocalFile uploads the local file at src to dst on the target.
+func (p *provisioner) uploadLocalFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %v", src, err)
 	}
+	defer f.Close()
 
+	o.Output("Uploading " + filepath.Base(src))
+	err = cancelable(ctx, func() error {
+		return comm.Upload(dst, f)
+	})
+	if err != nil {
+		return fmt.Errorf("Uploading %s failed: %v", src, err)
+	}
 	return nil
 }
 
@@ -411,7 +1176,6 @@ func decodeConfig(d *schema.ResourceData) (*provisioner, error) {
 	p := &provisioner{
 		Version:          d.Get("version").(string),
 		Peer:             d.Get("peer").(string),
-		Services:         getServices(d.Get("service").(*schema.Set).List()),
 		UseSudo:          d.Get("use_sudo").(bool),
 		AcceptLicense:    d.Get("accept_license").(bool),
 		ServiceType:      d.Get("service_type").(string),
@@ -421,22 +1185,68 @@ func decodeConfig(d *schema.ResourceData) (*provisioner, error) {
 		PermanentPeer:    d.Get("permanent_peer").(bool),
 		ListenGossip:     d.Get("listen_gossip").(string),
 		ListenHTTP:       d.Get("listen_http").(string),
+		ListenCtl:        d.Get("listen_ctl").(string),
 		URL:              d.Get("url").(string),
 		Channel:          d.Get("channel").(string),
 		Events:           d.Get("events").(string),
 		OverrideName:     d.Get("override_name").(string),
 		Organization:     d.Get("organization").(string),
 		BuilderAuthToken: d.Get("builder_auth_token").(string),
+		OSType:           d.Get("os_type").(string),
+		Destroy:          d.Get("destroy").(bool),
+		RemoveHab:        d.Get("remove_hab").(bool),
+		Offline:          getOffline(d.Get("offline").([]interface{})),
+		Supervisors:      getSupervisors(d.Get("supervisor").([]interface{})),
+		SystemdUnit:      getSystemdUnit(d.Get("systemd_unit").([]interface{})),
+		InstallScriptURL: d.Get("install_script_url").(string),
+		InstallChecksum:  strings.ToLower(d.Get("install_checksum").(string)),
+		RenderOnly:       d.Get("render_only").(string),
+		DiagnosticLines:  d.Get("diagnostic_lines").(int),
+		Retry:            defaultRetryPolicy,
+	}
+
+	if retry := getRetryPolicy(d.Get("retry").([]interface{})); retry != nil {
+		p.Retry = *retry
+	}
+
+	var err error
+	if p.Services, err = getServices(d.Get("service").(*schema.Set).List()); err != nil {
+		return nil, err
+	}
+	if p.ReadyTimeout, err = time.ParseDuration(d.Get("ready_timeout").(string)); err != nil {
+		return nil, fmt.Errorf("Error parsing ready_timeout: %v", err)
+	}
+	if p.ReadyBackoff, err = time.ParseDuration(d.Get("ready_backoff").(string)); err != nil {
+		return nil, fmt.Errorf("Error parsing ready_backoff: %v", err)
+	}
+	if p.InstallTimeout, err = time.ParseDuration(d.Get("install_timeout").(string)); err != nil {
+		return nil, fmt.Errorf("Error parsing install_timeout: %v", err)
+	}
+	if p.StartTimeout, err = time.ParseDuration(d.Get("start_timeout").(string)); err != nil {
+		return nil, fmt.Errorf("Error parsing start_timeout: %v", err)
+	}
+	if p.LoadTimeout, err = time.ParseDuration(d.Get("load_timeout").(string)); err != nil {
+		return nil, fmt.Errorf("Error parsing load_timeout: %v", err)
+	}
+
+	p.secrets.Add(p.BuilderAuthToken)
+	p.secrets.AddKey(p.RingKeyContent)
+	for _, service := range p.Services {
+		p.secrets.AddKey(service.ServiceGroupKey)
 	}
 
 	return p, nil
 }
 
-func getServices(v []interface{}) []Service {
+func getServices(v []interface{}) ([]Service, error) {
 	services := make([]Service, 0, len(v))
 	for _, rawServiceData := range v {
 		serviceData := rawServiceData.(map[string]interface{})
 		name := (serviceData["name"].(string))
+		ident, err := parsePackageIdent(name)
+		if err != nil {
+			return nil, err
+		}
 		strategy := (serviceData["strategy"].(string))
 		topology := (serviceData["topology"].(string))
 		channel := (serviceData["channel"].(string))
@@ -446,35 +1256,141 @@ func getServices(v []interface{}) []Service {
 		env := (serviceData["environment"].(string))
 		override := (serviceData["override_name"].(string))
 		userToml := (serviceData["user_toml"].(string))
+		if config := getStringMap(serviceData["config"].(map[string]interface{})); len(config) > 0 {
+			types := getStringMap(serviceData["config_types"].(map[string]interface{}))
+			if userToml, err = configTOML(config, types); err != nil {
+				return nil, fmt.Errorf("Error parsing config of service %s: %v", name, err)
+			}
+		}
 		serviceGroupKey := (serviceData["service_key"].(string))
-		var bindStrings []string
+		hart := (serviceData["hart"].(string))
+		supervisor := (serviceData["supervisor"].(string))
+		applyConfig := (serviceData["apply_config"].(string))
+		waitForHealth := (serviceData["wait_for_health"].(bool))
+		healthTimeout, err := time.ParseDuration(serviceData["health_timeout"].(string))
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing health_timeout of service %s: %v", name, err)
+		}
+		retry := getRetryPolicy(serviceData["retry"].([]interface{}))
 		binds := getBinds(serviceData["bind"].(*schema.Set).List())
 		for _, b := range serviceData["binds"].([]interface{}) {
 			bind, err := getBindFromString(b.(string))
 			if err != nil {
-				return nil
+				return nil, fmt.Errorf("Error parsing binds of service %s: %v", name, err)
 			}
 			binds = append(binds, bind)
 		}
 
 		service := Service{
 			Name:            name,
+			Ident:           ident,
 			Strategy:        strategy,
 			Topology:        topology,
 			Channel:         channel,
 			Group:           group,
 			URL:             url,
 			UserTOML:        userToml,
-			BindStrings:     bindStrings,
 			Binds:           binds,
 			AppName:         app,
 			Environment:     env,
 			OverrideName:    override,
 			ServiceGroupKey: serviceGroupKey,
+			Hart:            hart,
+			Supervisor:      supervisor,
+			WaitForHealth:   waitForHealth,
+			HealthTimeout:   healthTimeout,
+			Retry:           retry,
+			ApplyConfig:     applyConfig,
 		}
 		services = append(services, service)
 	}
-	return services
+	return services, nil
+}
+
+func getOffline(v []interface{}) *Offline {
+	if len(v) == 0 || v[0] == nil {
+		return nil
+	}
+	offlineData := v[0].(map[string]interface{})
+	return &Offline{
+		HabArchive:     offlineData["hab_archive"].(string),
+		HabSup:         offlineData["hab_sup"].(string),
+		HabLauncher:    offlineData["hab_launcher"].(string),
+		Busybox:        offlineData["busybox"].(string),
+		WindowsService: offlineData["windows_service"].(string),
+	}
+}
+
+func getSupervisors(v []interface{}) []Supervisor {
+	supervisors := make([]Supervisor, 0, len(v))
+	for _, rawSupervisorData := range v {
+		supervisorData := rawSupervisorData.(map[string]interface{})
+		name := supervisorData["override_name"].(string)
+		serviceName := supervisorData["service_name"].(string)
+		if serviceName == "" {
+			serviceName = "hab-sup-" + name
+		}
+		supervisor := Supervisor{
+			OverrideName:  name,
+			ServiceName:   serviceName,
+			ListenGossip:  supervisorData["listen_gossip"].(string),
+			ListenHTTP:    supervisorData["listen_http"].(string),
+			ListenCtl:     supervisorData["listen_ctl"].(string),
+			Peer:          supervisorData["peer"].(string),
+			PermanentPeer: supervisorData["permanent_peer"].(bool),
+			RingKey:       supervisorData["ring_key"].(string),
+		}
+		supervisors = append(supervisors, supervisor)
+	}
+	return supervisors
+}
+
+func getRetryPolicy(v []interface{}) *RetryPolicy {
+	if len(v) == 0 || v[0] == nil {
+		return nil
+	}
+	retryData := v[0].(map[string]interface{})
+	initialDelay, _ := time.ParseDuration(retryData["initial_delay"].(string))
+	maxDelay, _ := time.ParseDuration(retryData["max_delay"].(string))
+	return &RetryPolicy{
+		Attempts:     retryData["attempts"].(int),
+		InitialDelay: initialDelay,
+		MaxDelay:     maxDelay,
+		Jitter:       retryData["jitter"].(float64),
+	}
+}
+
+func getSystemdUnit(v []interface{}) *SystemdUnit {
+	if len(v) == 0 || v[0] == nil {
+		return nil
+	}
+	unitData := v[0].(map[string]interface{})
+	return &SystemdUnit{
+		Environment: getStringMap(unitData["environment"].(map[string]interface{})),
+		LimitNOFILE: unitData["limit_nofile"].(string),
+		User:        unitData["user"].(string),
+		Group:       unitData["group"].(string),
+		After:       getStrings(unitData["after"].([]interface{})),
+		Wants:       getStrings(unitData["wants"].([]interface{})),
+		RestartSec:  unitData["restart_sec"].(string),
+		Overrides:   getStringMap(unitData["overrides"].(map[string]interface{})),
+	}
+}
+
+func getStrings(v []interface{}) []string {
+	strs := make([]string, 0, len(v))
+	for _, s := range v {
+		strs = append(strs, s.(string))
+	}
+	return strs
+}
+
+func getStringMap(v map[string]interface{}) map[string]string {
+	m := make(map[string]string, len(v))
+	for key, value := range v {
+		m[key] = value.(string)
+	}
+	return m
 }
 
 func getBinds(v []interface{}) []Bind {
@@ -484,20 +1400,148 @@ func getBinds(v []interface{}) []Bind {
 		alias := bindData["alias"].(string)
 		service := bindData["service"].(string)
 		group := bindData["group"].(string)
+		org, _ := bindData["org"].(string)
 		bind := Bind{
 			Alias:   alias,
 			Service: service,
 			Group:   group,
+			Org:     org,
 		}
 		binds = append(binds, bind)
 	}
 	return binds
 }
 
-func (s *Service) getPackageName(fullName string) string {
-	return strings.Split(fullName, "/")[1]
+// supName returns the name of the supervisor state directory.
+func (p *provisioner) supName() string {
+	if p.OverrideName != "" {
+		return p.OverrideName
+	}
+	return "default"
+}
+
+// supervisors returns a provisioner for each supervisor to run on the target,
+// starting with the top level one, holding the services loaded into it.
+func (p *provisioner) supervisors() []*provisioner {
+	primary := *p
+	sups := []*provisioner{&primary}
+	for _, s := range p.Supervisors {
+		sup := *p
+		sup.OverrideName = s.OverrideName
+		sup.ServiceName = s.ServiceName
+		sup.ListenGossip = s.ListenGossip
+		sup.ListenHTTP = s.ListenHTTP
+		sup.ListenCtl = s.ListenCtl
+		sup.Peer = s.Peer
+		sup.PermanentPeer = s.PermanentPeer
+		sup.RingKey = s.RingKey
+		sups = append(sups, &sup)
+	}
+
+	for _, sup := range sups {
+		sup.Services = nil
+	}
+	for _, service := range p.Services {
+		sup := sups[0]
+		for _, s := range sups[1:] {
+			if service.Supervisor == s.OverrideName {
+				sup = s
+			}
+		}
+		sup.Services = append(sup.Services, service)
+	}
+	return sups
+}
+
+// listenPort returns the port of a listen address.
+func listenPort(listen string) string {
+	return listen[strings.LastIndex(listen, ":")+1:]
+}
+
+// supOptions returns the options passed to hab sup run.
+func (p *provisioner) supOptions() []string {
+	cmd := newCommand().
+		Flag("-I", p.PermanentPeer).
+		Option("--listen-gossip", p.ListenGossip).
+		Option("--listen-http", p.ListenHTTP).
+		Option("--listen-ctl", p.ListenCtl).
+		Option("--peer", p.Peer).
+		Option("--ring", p.RingKey).
+		Option("--url", p.URL).
+		Option("--channel", p.Channel).
+		Option("--events", p.Events).
+		Option("--override-name", p.OverrideName).
+		Option("--org", p.Organization)
+	return cmd.args
+}
+
+// loadOptions returns the options passed to hab svc load.
+func (s *Service) loadOptions() []string {
+	cmd := newCommand().
+		Option("--topology", s.Topology).
+		Option("--strategy", s.Strategy).
+		Option("--channel", s.Channel).
+		Option("--url", s.URL).
+		Option("--group", s.Group)
+	for _, bind := range s.Binds {
+		cmd.Option("--bind", bind.toBindString())
+	}
+	return cmd.args
 }
 
 func (b *Bind) toBindString() string {
-	return fmt.Sprintf("%s:%s.%s", b.Alias, b.Service, b.Group)
+	bind := fmt.Sprintf("%s:%s.%s", b.Alias, b.Service, b.Group)
+	if b.Org != "" {
+		bind += "@" + b.Org
+	}
+	return bind
+}
+
+// getBindFromString parses a bind specification like backend:nginx.default or
+// backend:nginx.default@acme, binding to a service group of another
+// organization.
+func getBindFromString(bind string) (Bind, error) {
+	invalid := func(reason string) (Bind, error) {
+		return Bind{}, errors.New(bind + " is not a valid bind: " + reason + ", expected alias:service.group[@org].")
+	}
+
+	alias := strings.SplitN(bind, ":", 2)
+	if len(alias) != 2 {
+		return invalid("missing the alias")
+	}
+	org := strings.SplitN(alias[1], "@", 2)
+	if len(org) == 2 && org[1] == "" {
+		return invalid("missing the organization after @")
+	}
+	group := strings.SplitN(org[0], ".", 2)
+	if len(group) != 2 {
+		return invalid("missing the group")
+	}
+
+	b := Bind{Alias: alias[0], Service: group[0], Group: group[1]}
+	if len(org) == 2 {
+		b.Org = org[1]
+	}
+	if err := b.validate(); err != nil {
+		return invalid(err.Error())
+	}
+	return b, nil
+}
+
+// validate checks that every part of the bind is a valid name.
+func (b *Bind) validate() error {
+	parts := []struct{ name, value string }{
+		{"alias", b.Alias},
+		{"service", b.Service},
+		{"group", b.Group},
+	}
+	if b.Org != "" {
+		parts = append(parts, struct{ name, value string }{"organization", b.Org})
+	}
+	for _, part := range parts {
+		if !identPartPattern.MatchString(part.value) {
+			return fmt.Errorf("%s %q must only contain letters, digits, _ and -", part.name, part.value)
+		}
+	}
+	return nil
 }
@@ -1,9 +1,12 @@
 package habitat
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/config/hcl2shim"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 )
 
@@ -65,6 +68,453 @@ func TestResourceProvisioner_Validate_bad_service_config(t *testing.T) {
 	}
 }
 
+func TestResourceProvisioner_Validate_bad_service_ident(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "redis"},
+			map[string]interface{}{"name": "core/redis//20180801003001"},
+			map[string]interface{}{"name": "core/redis/4.0.10"},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Should have two errors, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_binds(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{
+				"name":  "core/app",
+				"binds": []interface{}{"backend:nginx.default", "backend:nginx.default@acme", "nginx.default", "backend:nginx", "backend:nginx.default@", hcl2shim.UnknownVariableValue},
+				"bind": []map[string]interface{}{
+					map[string]interface{}{"alias": "db", "service": "postgresql", "group": "prod", "org": "acme"},
+					map[string]interface{}{"alias": "queue", "service": "rabbitmq", "group": hcl2shim.UnknownVariableValue},
+					map[string]interface{}{"alias": "cache", "service": "redis", "group": "bad group"},
+				},
+			},
+		},
+	})
+
+	// The interpolated binds are only known on apply
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 4 {
+		t.Fatalf("Should have four errors, got %v", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "Service core/app") {
+			t.Errorf("expected the service in the error, got %v", err)
+		}
+	}
+}
+
+func TestResourceProvisioner_Validate_user_toml(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "user_toml": "port = 6380\nprot = 1\n", "default_toml": "testdata/redis_default.toml"},
+			map[string]interface{}{"name": "core/nginx", "user_toml": "[http\nkeepalive = 60\n"},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) != 1 {
+		t.Fatalf("Should have one warning, got %v", warn)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "core/nginx") {
+		t.Fatalf("Should have one error for core/nginx, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_user_toml_computed(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "user_toml": hcl2shim.UnknownVariableValue, "default_toml": "testdata/redis_default.toml"},
+			map[string]interface{}{"name": "core/nginx", "user_toml": "port = 80\n", "default_toml": hcl2shim.UnknownVariableValue},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_config(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "config": map[string]interface{}{"port": 6380, "prot": "1"}, "default_toml": "testdata/redis_default.toml"},
+			map[string]interface{}{"name": "core/nginx", "config": map[string]interface{}{"http.keepalive": "60"}, "user_toml": "[http]\n"},
+			map[string]interface{}{"name": "core/postgresql", "config": map[string]interface{}{"bad key": "1"}},
+			map[string]interface{}{"name": "core/haproxy", "config": map[string]interface{}{"maxconn": "lots"}, "config_types": map[string]interface{}{"maxconn": "integer"}},
+			map[string]interface{}{"name": "core/consul", "config": map[string]interface{}{"port": hcl2shim.UnknownVariableValue}, "config_types": map[string]interface{}{"port": "integer"}},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) != 1 {
+		t.Fatalf("Should have one warning, got %v", warn)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("Should have three errors, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_apply_config(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "user_toml": "port = 6380\n", "apply_config": "hash"},
+			map[string]interface{}{"name": "core/nginx", "config": map[string]interface{}{"worker_processes": "4"}, "apply_config": "sometimes"},
+			map[string]interface{}{"name": "core/postgresql", "apply_config": "timestamp"},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Should have two errors, got %v", errs)
+	}
+}
+
+func TestGetBindFromString(t *testing.T) {
+	cases := map[string]Bind{
+		"backend:nginx.default":      {Alias: "backend", Service: "nginx", Group: "default"},
+		"backend:nginx.default@acme": {Alias: "backend", Service: "nginx", Group: "default", Org: "acme"},
+	}
+	for spec, expected := range cases {
+		bind, err := getBindFromString(spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", spec, err)
+			continue
+		}
+		if bind != expected {
+			t.Errorf("%s: expected %#v, got %#v", spec, expected, bind)
+		}
+		if bind.toBindString() != spec {
+			t.Errorf("%s: unexpected bind string %s", spec, bind.toBindString())
+		}
+	}
+
+	invalid := map[string]string{
+		"nginx.default":           "missing the alias",
+		"backend:nginx":           "missing the group",
+		"backend:nginx.default@":  "missing the organization",
+		"backend:nginx.prod.east": `group "prod.east"`,
+		":nginx.default":          `alias ""`,
+		"backend:ng inx.default":  `service "ng inx"`,
+		"backend:nginx.a@b@c":     `organization "b@c"`,
+	}
+	for spec, expected := range invalid {
+		_, err := getBindFromString(spec)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error containing %q, got %v", spec, expected, err)
+		}
+	}
+}
+
+func TestDecodeConfig_badBinds(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().Schema, map[string]interface{}{
+		"accept_license": true,
+		"service": []interface{}{
+			map[string]interface{}{"name": "core/app", "binds": []interface{}{"backend:nginx.default", "nginx.default"}},
+		},
+	})
+
+	_, err := decodeConfig(d)
+	if err == nil || !strings.Contains(err.Error(), "core/app") {
+		t.Fatalf("expected an error naming the service, got %v", err)
+	}
+}
+
+func TestDecodeConfig_config(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().Schema, map[string]interface{}{
+		"accept_license": true,
+		"service": []interface{}{
+			map[string]interface{}{
+				"name":         "core/redis",
+				"config":       map[string]interface{}{"port": "6380", "save.seconds": "60", "version": "1.10"},
+				"config_types": map[string]interface{}{"port": "integer"},
+			},
+		},
+	})
+
+	p, err := decodeConfig(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "port = 6380\nversion = \"1.10\"\n\n[save]\n  seconds = \"60\"\n"
+	if p.Services[0].UserTOML != expected {
+		t.Errorf("expected user.toml %q, got %q", expected, p.Services[0].UserTOML)
+	}
+}
+
+func TestResourceProvisioner_Validate_bad_install_source(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license":     true,
+		"install_script_url": "not a url",
+		"install_checksum":   "abc123",
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Should have two errors")
+	}
+}
+
+func TestResourceProvisioner_Validate_keys(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license":   true,
+		"ring_key_content": "c2VjcmV0",
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "service_key": "BOX-SEC-1\nredis.default@org-20190101000000\n\nc2VjcmV0"},
+			map[string]interface{}{"name": "core/nginx", "service_key": "BOX-SEC-1"},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Should have two errors, got %v", errs)
+	}
+	for _, err := range errs {
+		if strings.Contains(err.Error(), "c2VjcmV0") {
+			t.Errorf("unexpected key content in the error: %v", err)
+		}
+	}
+}
+
+func TestResourceProvisioner_Validate_offline(t *testing.T) {
+	offline := map[string]interface{}{"hab_archive": "hab.tar.gz", "hab_sup": "sup.hart", "hab_launcher": "launcher.hart"}
+	cases := []struct {
+		osType string
+		extra  map[string]interface{}
+		errs   int
+	}{
+		{"linux", nil, 1},
+		{"linux", map[string]interface{}{"busybox": "busybox.hart"}, 0},
+		{"windows", map[string]interface{}{"busybox": "busybox.hart"}, 1},
+		{"windows", map[string]interface{}{"windows_service": "service.hart"}, 0},
+		{"", nil, 1},
+		{"", map[string]interface{}{"windows_service": "service.hart"}, 0},
+	}
+
+	for _, tc := range cases {
+		block := map[string]interface{}{}
+		for k, v := range offline {
+			block[k] = v
+		}
+		for k, v := range tc.extra {
+			block[k] = v
+		}
+		raw := map[string]interface{}{
+			"accept_license": true,
+			"offline":        []map[string]interface{}{block},
+		}
+		if tc.osType != "" {
+			raw["os_type"] = tc.osType
+		}
+
+		_, errs := Provisioner().Validate(testConfig(t, raw))
+		if len(errs) != tc.errs {
+			t.Errorf("%q %v: expected %d errors, got %v", tc.osType, tc.extra, tc.errs, errs)
+		}
+	}
+}
+
+func TestResourceProvisioner_Validate_windows_version(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"os_type":        "windows",
+		"version":        "0.79.1",
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) != 1 {
+		t.Fatalf("Should have one warning")
+	}
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_install_script_url_version(t *testing.T) {
+	for osType, warnings := range map[string]int{"": 0, "linux": 0, "windows": 1} {
+		raw := map[string]interface{}{
+			"accept_license":     true,
+			"version":            "0.90.6",
+			"install_script_url": "https://example.com/install.sh",
+		}
+		if osType != "" {
+			raw["os_type"] = osType
+		}
+
+		warn, errs := Provisioner().Validate(testConfig(t, raw))
+		if len(warn) != warnings {
+			t.Errorf("%q: expected %d warnings, got %v", osType, warnings, warn)
+		}
+		if len(errs) > 0 {
+			t.Errorf("%q: Errors: %v", osType, errs)
+		}
+	}
+}
+
+func TestResourceProvisioner_Validate_bad_service_name(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service_type":   "auto",
+		"service_name":   "hab sup/../x",
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Should have one error, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_systemd_unit(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"systemd_unit": []map[string]interface{}{
+			map[string]interface{}{
+				"environment":  map[string]interface{}{"GOOD_NAME": "x", "bad-name": "y"},
+				"limit_nofile": "lots",
+				"user":         "hab\nExecStartPre=/bin/evil",
+				"after":        []interface{}{"network-online.target", "bad unit"},
+				"restart_sec":  "5s",
+				"overrides":    map[string]interface{}{"Service.TimeoutStopSec": "30", "Bogus.Key": "1", "Service.Nice": "1\n[Unit]"},
+			},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 6 {
+		t.Fatalf("Should have six errors, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_retry(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"retry": []map[string]interface{}{
+			map[string]interface{}{"attempts": 0, "initial_delay": "soon", "jitter": 1.5},
+		},
+		"service": []map[string]interface{}{
+			map[string]interface{}{
+				"name": "core/redis",
+				"retry": []map[string]interface{}{
+					map[string]interface{}{"attempts": 5, "max_delay": "-1s"},
+				},
+			},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 4 {
+		t.Fatalf("Should have four errors, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_render_only(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"service_type":   "auto",
+		"render_only":    "provision.sh",
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Should have one error, got %v", errs)
+	}
+}
+
+func TestResourceProvisioner_Validate_supervisors(t *testing.T) {
+	c := testConfig(t, map[string]interface{}{
+		"accept_license": true,
+		"override_name":  "ring-a",
+		"supervisor": []map[string]interface{}{
+			map[string]interface{}{"override_name": "ring-b", "listen_gossip": "0.0.0.0:9648", "listen_http": "0.0.0.0:9641", "listen_ctl": "127.0.0.1:9642"},
+			map[string]interface{}{"override_name": "ring-b", "listen_gossip": "0.0.0.0:9658", "listen_http": "0.0.0.0:9631", "listen_ctl": "127.0.0.1:9652"},
+		},
+		"service": []map[string]interface{}{
+			map[string]interface{}{"name": "core/redis", "supervisor": "ring-a"},
+			map[string]interface{}{"name": "core/redis", "supervisor": "ring-c"},
+		},
+	})
+
+	warn, errs := Provisioner().Validate(c)
+	if len(warn) > 0 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+	// A duplicate name, service_name, port and an unknown supervisor
+	if len(errs) != 4 {
+		t.Fatalf("Should have four errors, got %v", errs)
+	}
+}
+
+func TestProvisioner_supervisors(t *testing.T) {
+	p := &provisioner{
+		ServiceName: "hab-supervisor",
+		Supervisors: []Supervisor{{OverrideName: "ring-b", ServiceName: "hab-sup-ring-b", ListenCtl: "127.0.0.1:9642"}},
+		Services: []Service{
+			{Name: "core/redis"},
+			{Name: "core/nginx", Supervisor: "ring-b"},
+			{Name: "core/postgresql", Supervisor: "default"},
+		},
+	}
+
+	sups := p.supervisors()
+	if len(sups) != 2 {
+		t.Fatalf("expected 2 supervisors, got %d", len(sups))
+	}
+	if sups[0].supName() != "default" || len(sups[0].Services) != 2 {
+		t.Errorf("unexpected default supervisor: %#v", sups[0])
+	}
+	if sups[1].supName() != "ring-b" || sups[1].ServiceName != "hab-sup-ring-b" || sups[1].ListenCtl != "127.0.0.1:9642" {
+		t.Errorf("unexpected additional supervisor: %#v", sups[1])
+	}
+	if len(sups[1].Services) != 1 || sups[1].Services[0].Name != "core/nginx" {
+		t.Errorf("unexpected services of ring-b: %#v", sups[1].Services)
+	}
+	if len(p.Services) != 3 {
+		t.Errorf("services of the provisioner were modified: %#v", p.Services)
+	}
+}
+
 func testConfig(t *testing.T, c map[string]interface{}) *terraform.ResourceConfig {
 	r, err := config.NewRawConfig(c)
 	if err != nil {
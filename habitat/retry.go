@@ -0,0 +1,105 @@
+package habitat
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// RetryPolicy controls how often a failed network bound step is retried.
+type RetryPolicy struct {
+	Attempts     int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// Jitter is the fraction of the delay by which it is randomly varied
+	Jitter float64
+}
+
+// defaultRetryPolicy is used if no retry block is configured.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:     3,
+	InitialDelay: 2 * time.Second,
+	MaxDelay:     30 * time.Second,
+	Jitter:       0.2,
+}
+
+// step names a provisioning step in output and errors.
+type step string
+
+const (
+	stepDownloadInstaller step = "Downloading the Habitat installer"
+	stepInstallHab        step = "Installing Habitat"
+	stepInstallPackage    step = "Installing a Habitat package"
+	stepLoadService       step = "Loading a service"
+)
+
+// retryableSteps lists the steps that download from the network, and may fail
+// because of a transient problem of the network or Builder. Loading a service
+// only talks to the local supervisor, whose failures don't go away by
+// themselves, so it is never retried.
+var retryableSteps = map[step]bool{
+	stepDownloadInstaller: true,
+	stepInstallHab:        true,
+	stepInstallPackage:    true,
+	stepLoadService:       false,
+}
+
+// sleep waits for d, or until ctx is done. It is replaced in tests.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retry runs fn until it succeeds, retrying it according to policy if s is a
+// retryable step. It stops retrying once ctx is done.
+func (p *provisioner) retry(ctx context.Context, o terraform.UIOutput, s step, policy RetryPolicy, fn func() error) error {
+	attempts := 1
+	if retryableSteps[s] && policy.Attempts > 1 {
+		attempts = policy.Attempts
+	}
+
+	delay := policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts {
+			return err
+		}
+
+		wait := policy.jitter(delay)
+		o.Output(fmt.Sprintf("%s failed (attempt %d of %d), retrying in %s: %v", s, attempt, attempts, wait, err))
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+
+		delay *= 2
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// jitter varies delay randomly by up to the jitter fraction of the policy.
+func (r RetryPolicy) jitter(delay time.Duration) time.Duration {
+	if r.Jitter <= 0 {
+		return delay
+	}
+	return delay + time.Duration((rand.Float64()*2-1)*r.Jitter*float64(delay))
+}
+
+// retryPolicy returns the retry policy of service, or the global one if the
+// service has none.
+func (p *provisioner) retryPolicy(service Service) RetryPolicy {
+	if service.Retry != nil {
+		return *service.Retry
+	}
+	return p.Retry
+}
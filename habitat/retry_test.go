@@ -0,0 +1,127 @@
+package habitat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// stubSleep replaces sleep with a function recording the delays. The returned
+// function restores it.
+func stubSleep() (*[]time.Duration, func()) {
+	var delays []time.Duration
+	original := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return &delays, func() { sleep = original }
+}
+
+func TestRetryableSteps(t *testing.T) {
+	expected := map[step]bool{
+		stepDownloadInstaller: true,
+		stepInstallHab:        true,
+		stepInstallPackage:    true,
+		stepLoadService:       false,
+	}
+	if !reflect.DeepEqual(retryableSteps, expected) {
+		t.Errorf("expected %v, got %v", expected, retryableSteps)
+	}
+}
+
+func TestProvisioner_retry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, InitialDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	for s, retryable := range retryableSteps {
+		delays, restore := stubSleep()
+		defer restore()
+		calls := 0
+		err := new(provisioner).retry(context.Background(), new(terraform.MockUIOutput), s, policy, func() error {
+			calls++
+			return errors.New("failed")
+		})
+		if err == nil {
+			t.Fatalf("%s: expected an error", s)
+		}
+
+		expected := 1
+		if retryable {
+			expected = policy.Attempts
+		}
+		if calls != expected {
+			t.Errorf("%s: expected %d calls, got %d", s, expected, calls)
+		}
+		if retryable {
+			expectedDelays := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+			if !reflect.DeepEqual(*delays, expectedDelays) {
+				t.Errorf("%s: expected delays %v, got %v", s, expectedDelays, *delays)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_jitter(t *testing.T) {
+	policy := RetryPolicy{Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := policy.jitter(10 * time.Second); d < 5*time.Second || d > 15*time.Second {
+			t.Fatalf("expected a delay between 5s and 15s, got %s", d)
+		}
+	}
+}
+
+func TestLinuxPlatform_retryInstall(t *testing.T) {
+	_, restore := stubSleep()
+	defer restore()
+	comm := &fakeCommunicator{failures: map[string]int{"curl": 2, "./install.sh": 1}}
+	p := &linuxPlatform{&provisioner{Retry: defaultRetryPolicy}}
+
+	if err := p.runInstallScript(context.Background(), new(terraform.MockUIOutput), comm); err != nil {
+		t.Fatal(err)
+	}
+	if n := comm.count("curl"); n != 3 {
+		t.Errorf("expected 3 downloads, got %d", n)
+	}
+	if n := comm.count("./install.sh"); n != 2 {
+		t.Errorf("expected 2 installs, got %d", n)
+	}
+}
+
+func TestLinuxPlatform_retryService(t *testing.T) {
+	_, restore := stubSleep()
+	defer restore()
+	p := &linuxPlatform{&provisioner{Retry: RetryPolicy{Attempts: 2}}}
+
+	// The global policy gives up after two attempts
+	comm := &fakeCommunicator{failures: map[string]int{"hab pkg install": 2}}
+	service := Service{Name: "core/redis"}
+	if err := p.installHabPackage(context.Background(), new(terraform.MockUIOutput), comm, service); err == nil {
+		t.Error("expected an error")
+	}
+	if n := comm.count("hab pkg install"); n != 2 {
+		t.Errorf("expected 2 installs, got %d", n)
+	}
+
+	// The policy of the service overrides it
+	comm = &fakeCommunicator{failures: map[string]int{"hab pkg install": 2}}
+	service.Retry = &RetryPolicy{Attempts: 3}
+	if err := p.installHabPackage(context.Background(), new(terraform.MockUIOutput), comm, service); err != nil {
+		t.Error(err)
+	}
+	if n := comm.count("hab pkg install"); n != 3 {
+		t.Errorf("expected 3 installs, got %d", n)
+	}
+
+	// Loading a service is never retried
+	comm = &fakeCommunicator{failures: map[string]int{"svc load": 1}}
+	if err := p.StartHabService(context.Background(), new(terraform.MockUIOutput), comm, service); err == nil {
+		t.Error("expected an error")
+	}
+	if n := comm.count("svc load"); n != 1 {
+		t.Errorf("expected 1 load, got %d", n)
+	}
+}
@@ -0,0 +1,84 @@
+package habitat
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// secretMask replaces sensitive values in output and errors.
+const secretMask = "<sensitive>"
+
+// secrets is a registry of sensitive values, such as the Builder auth token
+// and key contents, that must never be shown in output or returned errors.
+type secrets struct {
+	values []string
+}
+
+// Add registers a sensitive value.
+func (s *secrets) Add(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	s.values = append(s.values, value)
+
+	// Keep the longest values first, so a value containing another one is
+	// masked as a whole.
+	sort.SliceStable(s.values, func(i, j int) bool {
+		return len(s.values[i]) > len(s.values[j])
+	})
+}
+
+// AddKey registers the content of a Habitat key file. Besides the complete
+// content, the key material itself is registered on its own, as output is
+// masked line by line. The key type and name on the first two lines are not
+// sensitive.
+func (s *secrets) AddKey(content string) {
+	s.Add(content)
+	lines := strings.Split(content, "\n")
+	for i := 2; i < len(lines); i++ {
+		s.Add(lines[i])
+	}
+}
+
+// keyName returns the name of a Habitat key from the second line of its
+// content. The content itself is never part of the error.
+func keyName(content string) (string, error) {
+	lines := strings.Split(content, "\n")
+	if len(lines) < 3 || strings.TrimSpace(lines[0]) == "" || strings.TrimSpace(lines[1]) == "" {
+		return "", errors.New("the key must start with its type and name on separate lines")
+	}
+	return strings.TrimSpace(lines[1]), nil
+}
+
+// Mask replaces all registered values in str.
+func (s *secrets) Mask(str string) string {
+	for _, value := range s.values {
+		str = strings.Replace(str, value, secretMask, -1)
+	}
+	return str
+}
+
+// Error masks all registered values in the message of err.
+func (s *secrets) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if msg := s.Mask(err.Error()); msg != err.Error() {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// maskedOutput is a terraform.UIOutput that masks all registered secrets.
+type maskedOutput struct {
+	terraform.UIOutput
+	secrets *secrets
+}
+
+func (o *maskedOutput) Output(line string) {
+	o.UIOutput.Output(o.secrets.Mask(line))
+}
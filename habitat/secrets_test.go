@@ -0,0 +1,61 @@
+package habitat
+
+import (
+	"errors"
+	"testing"
+)
+
+const testKey = "SYM-SEC-1\nfoo-20170101\n\nc2VjcmV0IGtleSBtYXRlcmlhbA==\n"
+
+func TestSecrets_Mask(t *testing.T) {
+	var s secrets
+	s.Add("token")
+	s.Add("")
+	s.AddKey(testKey)
+
+	cases := map[string]string{
+		"env HAB_AUTH_TOKEN=token hab":     "env HAB_AUTH_TOKEN=<sensitive> hab",
+		"c2VjcmV0IGtleSBtYXRlcmlhbA==":     "<sensitive>",
+		"Uploading ring key: foo-20170101": "Uploading ring key: foo-20170101",
+		"echo '" + testKey + "' | hab":     "echo '<sensitive>\n' | hab",
+		"nothing to see here":              "nothing to see here",
+	}
+
+	for input, expected := range cases {
+		if got := s.Mask(input); got != expected {
+			t.Errorf("Mask(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestSecrets_Error(t *testing.T) {
+	var s secrets
+	s.Add("token")
+
+	if err := s.Error(nil); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	plain := errors.New("exit status 1")
+	if err := s.Error(plain); err != plain {
+		t.Errorf("expected the original error, got %v", err)
+	}
+
+	err := s.Error(errors.New(`Error executing command "env HAB_AUTH_TOKEN=token hab": EOF`))
+	if expected := `Error executing command "env HAB_AUTH_TOKEN=<sensitive> hab": EOF`; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	name, err := keyName("SYM-SEC-1\ntest-ring-20190101000000\n\nc2VjcmV0")
+	if err != nil || name != "test-ring-20190101000000" {
+		t.Errorf("unexpected name %q and error %v", name, err)
+	}
+
+	for _, content := range []string{"", "c2VjcmV0", "SYM-SEC-1\n", "SYM-SEC-1\n\n\nc2VjcmV0"} {
+		if _, err := keyName(content); err == nil {
+			t.Errorf("%q: expected an error", content)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package habitat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// serviceStatus is the state of a loaded service as reported by hab svc status.
+type serviceStatus struct {
+	Ident string
+	Group string
+	// Spec holds the options the service was loaded with, if the spec file
+	// of the supervisor could be read.
+	Spec *serviceSpec
+}
+
+// serviceSpec is the part of the spec file the supervisor keeps for every
+// loaded service that is set by the options of hab svc load.
+type serviceSpec struct {
+	Channel        string   `toml:"channel"`
+	Topology       string   `toml:"topology"`
+	UpdateStrategy string   `toml:"update_strategy"`
+	BldrURL        string   `toml:"bldr_url"`
+	Binds          []string `toml:"binds"`
+}
+
+// parseServiceStatus parses the output of hab svc status for a single service.
+// It returns false if the output doesn't describe a loaded service.
+func parseServiceStatus(out string) (serviceStatus, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if _, err := parsePackageIdent(fields[0]); err != nil {
+			continue
+		}
+		return serviceStatus{Ident: fields[0], Group: fields[len(fields)-1]}, true
+	}
+	return serviceStatus{}, false
+}
+
+// parseServiceSpec parses the spec file of a loaded service.
+func parseServiceSpec(out string) (*serviceSpec, bool) {
+	spec := new(serviceSpec)
+	if err := toml.Unmarshal([]byte(out), spec); err != nil {
+		return nil, false
+	}
+	return spec, true
+}
+
+// matches reports whether the loaded service satisfies the package and
+// service group of the configured service. The service group includes the
+// organization of the supervisor, if any.
+func (s serviceStatus) matches(service Service, org string) bool {
+	loaded, err := parsePackageIdent(s.Ident)
+	if err != nil || !service.Ident.satisfiedBy(loaded) {
+		return false
+	}
+	return s.Group == service.serviceGroup(org)
+}
+
+// upToDate reports whether the loaded service matches the configured service,
+// including the options it was loaded with, so loading it again can be
+// skipped.
+func (s serviceStatus) upToDate(service Service, org string) bool {
+	if !s.matches(service, org) || s.Spec == nil {
+		return false
+	}
+
+	configured := serviceSpec{
+		Channel:        valueOrDefault(service.Channel, "stable"),
+		Topology:       valueOrDefault(service.Topology, "standalone"),
+		UpdateStrategy: valueOrDefault(service.Strategy, "none"),
+		BldrURL:        strings.TrimSuffix(valueOrDefault(service.URL, "https://bldr.habitat.sh"), "/"),
+	}
+	for _, bind := range service.Binds {
+		configured.Binds = append(configured.Binds, bind.toBindString())
+	}
+
+	loaded := *s.Spec
+	loaded.BldrURL = strings.TrimSuffix(loaded.BldrURL, "/")
+	loaded.Binds = append([]string(nil), s.Spec.Binds...)
+	if configured.Channel != loaded.Channel ||
+		configured.Topology != loaded.Topology ||
+		configured.UpdateStrategy != loaded.UpdateStrategy ||
+		configured.BldrURL != loaded.BldrURL ||
+		len(configured.Binds) != len(loaded.Binds) {
+		return false
+	}
+
+	sort.Strings(configured.Binds)
+	sort.Strings(loaded.Binds)
+	for i := range configured.Binds {
+		if configured.Binds[i] != loaded.Binds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func valueOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// parseHabVersion parses the output of hab --version, for example
+// "hab 0.79.1/20190410220617", and returns the version.
+func parseHabVersion(out string) (string, bool) {
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "hab" {
+		return "", false
+	}
+	return strings.Split(fields[1], "/")[0], true
+}
+
+// habSatisfied reports whether an installed hab version satisfies the
+// configuration, so the installation can be skipped. The version of an offline
+// archive isn't known, so any installed version satisfies it.
+func (p *provisioner) habSatisfied(version string) bool {
+	return p.Offline != nil || p.Version == "" || version == p.Version
+}
+
+// sha256Hex returns the hex encoded SHA-256 checksum of content.
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
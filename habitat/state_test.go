@@ -0,0 +1,215 @@
+package habitat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestParseServiceStatus(t *testing.T) {
+	out := "package                          type        desired  state  elapsed (s)  pid    group\n" +
+		"core/redis/4.0.10/20180801003001  standalone  up       up     12           1234   redis.default\n"
+
+	status, ok := parseServiceStatus(out)
+	if !ok {
+		t.Fatal("expected a loaded service")
+	}
+	if status.Ident != "core/redis/4.0.10/20180801003001" || status.Group != "redis.default" {
+		t.Errorf("unexpected status: %#v", status)
+	}
+
+	for _, out := range []string{"", "No services loaded.\n", "package type desired state elapsed (s) pid group\n"} {
+		if _, ok := parseServiceStatus(out); ok {
+			t.Errorf("%q: expected no loaded service", out)
+		}
+	}
+}
+
+func TestServiceStatus_matches(t *testing.T) {
+	status := serviceStatus{Ident: "core/redis/4.0.10/20180801003001", Group: "redis.default"}
+
+	cases := []struct {
+		name     string
+		group    string
+		org      string
+		expected bool
+	}{
+		{"core/redis", "", "", true},
+		{"core/redis/4.0.10", "", "", true},
+		{"core/redis/4.0.10/20180801003001", "default", "", true},
+		{"core/redis/4.0.1", "", "", false},
+		{"core/redis", "prod", "", false},
+		{"core/redis", "", "acme", false},
+		{"myorigin/redis", "", "", false},
+		{"core/redis/4.0.10/20180801003001/extra", "", "", false},
+	}
+
+	for _, tc := range cases {
+		ident, _ := parsePackageIdent(tc.name)
+		service := Service{Name: tc.name, Ident: ident, Group: tc.group}
+		if got := status.matches(service, tc.org); got != tc.expected {
+			t.Errorf("%#v %q: expected %t, got %t", service, tc.org, tc.expected, got)
+		}
+	}
+
+	status.Group = "redis.default@acme"
+	service := Service{Name: "core/redis", Ident: PackageIdent{Origin: "core", Name: "redis"}}
+	if !status.matches(service, "acme") {
+		t.Errorf("expected %#v to match in organization acme", status)
+	}
+}
+
+const testServiceSpec = `ident = "core/redis"
+group = "default"
+bldr_url = "https://bldr.habitat.sh"
+channel = "stable"
+topology = "leader"
+update_strategy = "none"
+binds = ["backend:nginx.default", "db:postgresql.prod"]
+binding_mode = "strict"
+desired_state = "up"
+`
+
+func TestServiceStatus_upToDate(t *testing.T) {
+	spec, ok := parseServiceSpec(testServiceSpec)
+	if !ok {
+		t.Fatal("expected a spec")
+	}
+	status := serviceStatus{Ident: "core/redis/4.0.10/20180801003001", Group: "redis.default", Spec: spec}
+
+	service := func(modify func(*Service)) Service {
+		s := Service{
+			Name:     "core/redis",
+			Ident:    PackageIdent{Origin: "core", Name: "redis"},
+			Topology: "leader",
+			Binds:    []Bind{{Alias: "db", Service: "postgresql", Group: "prod"}, {Alias: "backend", Service: "nginx", Group: "default"}},
+		}
+		if modify != nil {
+			modify(&s)
+		}
+		return s
+	}
+
+	if !status.upToDate(service(nil), "") {
+		t.Error("expected the loaded service to be up to date")
+	}
+	cases := map[string]func(*Service){
+		"topology": func(s *Service) { s.Topology = "" },
+		"strategy": func(s *Service) { s.Strategy = "rolling" },
+		"channel":  func(s *Service) { s.Channel = "unstable" },
+		"url":      func(s *Service) { s.URL = "https://bldr.example.com" },
+		"binds":    func(s *Service) { s.Binds = s.Binds[:1] },
+		"bind":     func(s *Service) { s.Binds[0].Group = "default" },
+	}
+	for name, modify := range cases {
+		if status.upToDate(service(modify), "") {
+			t.Errorf("%s: expected a changed option to need a reload", name)
+		}
+	}
+
+	status.Spec = nil
+	if status.upToDate(service(nil), "") {
+		t.Error("expected a service without a known spec to need a reload")
+	}
+}
+
+func TestLinuxPlatform_reloadChangedOptions(t *testing.T) {
+	comm := &fakeCommunicator{responses: map[string]string{
+		"svc status":                            "package type desired state elapsed (s) pid group\ncore/redis/4.0.10/20180801003001 standalone up up 12 1234 redis.default@acme\n",
+		"cat /hab/sup/default/specs/redis.spec": testServiceSpec,
+		"sha256sum":                             sha256Hex(nil) + "  /hab/svc/redis/user.toml\n",
+	}}
+	p := &linuxPlatform{&provisioner{Organization: "acme"}}
+	service := Service{
+		Name:     "core/redis",
+		Ident:    PackageIdent{Origin: "core", Name: "redis"},
+		Topology: "leader",
+		Binds:    []Bind{{Alias: "backend", Service: "nginx", Group: "default"}, {Alias: "db", Service: "postgresql", Group: "prod"}},
+	}
+
+	if err := p.StartHabService(context.Background(), new(terraform.MockUIOutput), comm, service); err != nil {
+		t.Fatal(err)
+	}
+	if n := comm.count("svc load"); n != 0 {
+		t.Errorf("expected the up to date service not to be loaded again, got %d loads", n)
+	}
+
+	service.Strategy = "rolling"
+	if err := p.StartHabService(context.Background(), new(terraform.MockUIOutput), comm, service); err != nil {
+		t.Fatal(err)
+	}
+	if n := comm.count("svc load core/redis --topology leader --strategy rolling"); n != 1 {
+		t.Errorf("expected the service to be reloaded with its changed options, got %v", comm.commands)
+	}
+	if n := comm.count("--force"); n != 1 {
+		t.Errorf("expected a forced reload, got %v", comm.commands)
+	}
+}
+
+func TestLinuxPlatform_restartUpdatedSupervisor(t *testing.T) {
+	p := &linuxPlatform{&provisioner{Version: "0.90.6", ServiceType: "systemd", ServiceName: "hab-supervisor", UseSudo: true}}
+	unit, err := p.renderInitScript(initSystems["systemd"], p.supOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The unit is unchanged, but the supervisor package is new
+	comm := &fakeCommunicator{
+		failures:  map[string]int{"hab pkg path": 1, ".service.d": 100},
+		responses: map[string]string{"sha256sum": sha256Hex(unit) + "  /etc/systemd/system/hab-supervisor.service\n"},
+	}
+	if err := p.StartHab(context.Background(), new(terraform.MockUIOutput), comm); err != nil {
+		t.Fatal(err)
+	}
+	if comm.count("hab install core/hab-sup/0.90.6") != 1 || comm.count("systemctl restart hab-supervisor.service") != 1 {
+		t.Errorf("expected the new supervisor to be installed and restarted, got %v", comm.commands)
+	}
+
+	// Without changes the running supervisor is left alone
+	comm.commands = nil
+	if err := p.StartHab(context.Background(), new(terraform.MockUIOutput), comm); err != nil {
+		t.Fatal(err)
+	}
+	if comm.count("systemctl restart") != 0 || comm.count("systemctl start hab-supervisor.service") != 1 {
+		t.Errorf("expected the supervisor to be started only, got %v", comm.commands)
+	}
+}
+
+func TestWindowsPlatform_restartUpdatedSupervisor(t *testing.T) {
+	comm := &fakeCommunicator{
+		scriptPath: "C:/Windows/Temp/terraform_1.cmd",
+		responses:  map[string]string{"hab --version": "hab 0.79.1/20190410220617\n"},
+	}
+	p := &windowsPlatform{&provisioner{Version: "0.90.6"}}
+	if err := p.InstallHab(context.Background(), new(terraform.MockUIOutput), comm); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.StartHab(context.Background(), new(terraform.MockUIOutput), comm); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := comm.transcript.String()
+	for _, expected := range []string{"Remove-Item -Recurse -Force c:\\habitat", "hab-x86_64-windows.zip", "$restart = $true"} {
+		if !strings.Contains(transcript, expected) {
+			t.Errorf("expected the update to contain %s, got:\n%s", expected, transcript)
+		}
+	}
+}
+
+func TestParseHabVersion(t *testing.T) {
+	cases := map[string]string{
+		"hab 0.79.1/20190410220617\n": "0.79.1",
+		"hab 1.5.0\n":                 "1.5.0",
+		"":                            "",
+		"command not found":           "",
+	}
+
+	for out, expected := range cases {
+		version, ok := parseHabVersion(out)
+		if version != expected || ok != (expected != "") {
+			t.Errorf("%q: expected %q, got %q", out, expected, version)
+		}
+	}
+}
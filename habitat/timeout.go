@@ -0,0 +1,46 @@
+package habitat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// withTimeout runs a step with a context that is cancelled after timeout, or
+// only when ctx is if timeout is 0. A step that runs out of time returns an
+// error naming it.
+func withTimeout(ctx context.Context, timeout time.Duration, name string, step func(context.Context) error) error {
+	if timeout <= 0 {
+		return step(ctx)
+	}
+
+	stepCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := step(stepCtx)
+	if err != nil && ctx.Err() == nil && stepCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s did not finish within %s", name, timeout)
+	}
+	return err
+}
+
+// cancelable runs fn, but returns as soon as ctx is done. The communicators
+// can't interrupt a remote command, so fn is left running in the background
+// when it is abandoned.
+func cancelable(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
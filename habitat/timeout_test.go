@@ -0,0 +1,67 @@
+package habitat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// hangingCommunicator starts commands that never exit.
+type hangingCommunicator struct {
+	communicator.Communicator
+}
+
+func (c *hangingCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	return nil
+}
+
+func TestProvisioner_runCommandCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	p := new(provisioner)
+	err := p.runCommand(ctx, new(terraform.MockUIOutput), new(hangingCommunicator), "hab pkg install core/redis", nil)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("expected a cancelled command, got %v", err)
+	}
+
+	if _, err := p.probeCommand(ctx, new(hangingCommunicator), "hab --version"); err != context.Canceled {
+		t.Fatalf("expected a cancelled probe, got %v", err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	wait := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := withTimeout(context.Background(), 10*time.Millisecond, "Installing habitat", wait)
+	if err == nil || err.Error() != "Installing habitat did not finish within 10ms" {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	// Cancelling the provisioner isn't reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := withTimeout(ctx, time.Minute, "Installing habitat", wait); err != context.Canceled {
+		t.Errorf("expected a cancellation, got %v", err)
+	}
+
+	// A timeout of 0 disables it
+	err = withTimeout(context.Background(), 0, "Installing habitat", func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("expected no deadline")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
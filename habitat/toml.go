@@ -0,0 +1,173 @@
+package habitat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// tomlErrorPattern matches the position go-toml prefixes its errors with.
+var tomlErrorPattern = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+
+// configTypes are the TOML types config values can be given with config_types.
+var configTypes = map[string]bool{"string": true, "integer": true, "float": true, "boolean": true}
+
+// validateUserTOML checks that the user_toml of a service is valid TOML. When
+// defaultTOML is the path of a local copy of the default.toml of the package,
+// keys that aren't in it are reported as warnings, as they are most likely
+// typos the service won't pick up.
+func validateUserTOML(service, userTOML, defaultTOML string) (ws []string, es []error) {
+	user, err := parseTOML(userTOML)
+	if err != nil {
+		return nil, []error{fmt.Errorf("user_toml of service %s is not valid TOML: %v.", service, err)}
+	}
+	if defaultTOML == "" {
+		return nil, nil
+	}
+
+	content, err := ioutil.ReadFile(defaultTOML)
+	if err != nil {
+		return nil, []error{fmt.Errorf("Error reading default_toml of service %s: %v", service, err)}
+	}
+	defaults, err := parseTOML(string(content))
+	if err != nil {
+		return nil, []error{fmt.Errorf("default_toml %s of service %s is not valid TOML: %v.", defaultTOML, service, err)}
+	}
+
+	for _, key := range unknownTOMLKeys(user, defaults, "") {
+		ws = append(ws, fmt.Sprintf("user_toml of service %s sets %s, which is not in %s.", service, key, defaultTOML))
+	}
+	return ws, nil
+}
+
+// parseTOML parses content, reporting the position of syntax errors as line
+// and column.
+func parseTOML(content string) (*toml.Tree, error) {
+	tree, err := toml.Load(content)
+	if err != nil {
+		if m := tomlErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+			return nil, fmt.Errorf("line %s, column %s: %s", m[1], m[2], m[3])
+		}
+		return nil, err
+	}
+	return tree, nil
+}
+
+// unknownTOMLKeys returns the sorted keys of user that aren't in defaults.
+// Tables are compared key by key, unless the table in defaults is empty.
+func unknownTOMLKeys(user, defaults *toml.Tree, prefix string) []string {
+	var unknown []string
+	for _, key := range user.Keys() {
+		path := prefix + tomlKey(key)
+		def := defaults.GetPath([]string{key})
+		if def == nil {
+			unknown = append(unknown, path)
+			continue
+		}
+
+		userTable, ok := user.GetPath([]string{key}).(*toml.Tree)
+		defTable, defOk := def.(*toml.Tree)
+		if ok && defOk && len(defTable.Keys()) > 0 {
+			unknown = append(unknown, unknownTOMLKeys(userTable, defTable, path+".")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// tomlKey quotes a key for use in a TOML path, if needed.
+func tomlKey(key string) string {
+	if identPartPattern.MatchString(key) {
+		return key
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// configTOML serializes the config of a service to TOML. Dotted keys like
+// save.seconds are written as tables. Values are written as strings, unless
+// types gives another type for the key. Keys are sorted, so the result is the
+// same on every run.
+func configTOML(config, types map[string]string) (string, error) {
+	if len(config) == 0 {
+		return "", nil
+	}
+	for key := range types {
+		if _, ok := config[key]; !ok {
+			return "", fmt.Errorf("config_types key %q is not set in config", key)
+		}
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	root := make(map[string]interface{})
+	for _, key := range keys {
+		parts := strings.Split(key, ".")
+		for _, part := range parts {
+			if !identPartPattern.MatchString(part) {
+				return "", fmt.Errorf("config key %q is not valid, every part must only contain letters, digits, _ and -", key)
+			}
+		}
+
+		table := root
+		for i, part := range parts[:len(parts)-1] {
+			switch v := table[part].(type) {
+			case nil:
+				next := make(map[string]interface{})
+				table[part] = next
+				table = next
+			case map[string]interface{}:
+				table = v
+			default:
+				return "", fmt.Errorf("config key %q conflicts with %q", key, strings.Join(parts[:i+1], "."))
+			}
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := table[last]; ok {
+			return "", fmt.Errorf("config key %q conflicts with the keys of table %q", key, key)
+		}
+		value, err := tomlValue(config[key], types[key])
+		if err != nil {
+			return "", fmt.Errorf("config key %q: %v", key, err)
+		}
+		table[last] = value
+	}
+
+	tree, err := toml.TreeFromMap(root)
+	if err != nil {
+		return "", err
+	}
+	return tree.ToTomlString()
+}
+
+// tomlValue converts value to the TOML type typ, a string by default.
+func tomlValue(value, typ string) (interface{}, error) {
+	switch typ {
+	case "", "string":
+		return value, nil
+	case "integer":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i, nil
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f, nil
+		}
+	case "boolean":
+		if value == "true" || value == "false" {
+			return value == "true", nil
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a valid type", typ)
+	}
+	return nil, fmt.Errorf("%q is not a valid %s", value, typ)
+}
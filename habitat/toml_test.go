@@ -0,0 +1,109 @@
+package habitat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateUserTOML(t *testing.T) {
+	ws, es := validateUserTOML("core/redis", "port = 6380\n\n[save]\nseconds = 60\n", "")
+	if len(ws) > 0 || len(es) > 0 {
+		t.Fatalf("unexpected warnings %v and errors %v", ws, es)
+	}
+
+	_, es = validateUserTOML("core/redis", "port = 6380\nsave = \n", "")
+	if len(es) != 1 || !strings.Contains(es[0].Error(), "core/redis") || !strings.Contains(es[0].Error(), "line 3, column 1") {
+		t.Fatalf("expected an error with the service and position, got %v", es)
+	}
+}
+
+func TestValidateUserTOML_defaultTOML(t *testing.T) {
+	user := "port = 6380\nprot = 1\n\n[save]\nsecond = 60\n\n[extra]\nanything = true\n\n[\"log.level\"]\nx = 1\n"
+	ws, es := validateUserTOML("core/redis", user, "testdata/redis_default.toml")
+	if len(es) > 0 {
+		t.Fatalf("Errors: %v", es)
+	}
+
+	var keys []string
+	for _, w := range ws {
+		keys = append(keys, strings.TrimSuffix(strings.Fields(w)[5], ","))
+	}
+	expected := []string{`"log.level"`, "prot", "save.second"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected warnings for %v, got %v", expected, ws)
+	}
+
+	if _, es := validateUserTOML("core/redis", user, "testdata/missing.toml"); len(es) != 1 {
+		t.Errorf("expected an error for a missing default_toml, got %v", es)
+	}
+}
+
+func TestConfigTOML(t *testing.T) {
+	config := map[string]string{
+		"port":           "6380",
+		"protected-mode": "no",
+		"save.seconds":   "60",
+		"save.ratio":     "0.5",
+		"tls.enabled":    "true",
+		"tls.ca.file":    "/hab/svc/redis/files/ca.pem",
+		"version":        "1.10",
+		"umask":          "007",
+		"debug":          "false",
+	}
+	types := map[string]string{
+		"port":         "integer",
+		"save.seconds": "integer",
+		"save.ratio":   "float",
+		"tls.enabled":  "boolean",
+		"version":      "string",
+	}
+	// Values without a type are strings, so version-like and leading zero
+	// values are kept as they are
+	expected := `debug = "false"
+port = 6380
+protected-mode = "no"
+umask = "007"
+version = "1.10"
+
+[save]
+  ratio = 0.5
+  seconds = 60
+
+[tls]
+  enabled = true
+
+  [tls.ca]
+    file = "/hab/svc/redis/files/ca.pem"
+`
+
+	for i := 0; i < 10; i++ {
+		out, err := configTOML(config, types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != expected {
+			t.Fatalf("expected:\n%s\ngot:\n%s", expected, out)
+		}
+	}
+}
+
+func TestConfigTOML_invalid(t *testing.T) {
+	cases := map[string]struct {
+		config map[string]string
+		types  map[string]string
+	}{
+		`"bad key"`:                     {map[string]string{"bad key": "1"}, nil},
+		`"save..x"`:                     {map[string]string{"save..x": "1"}, nil},
+		`conflicts with`:                {map[string]string{"save": "1", "save.seconds": "60"}, nil},
+		`"1.10" is not a valid integer`: {map[string]string{"port": "1.10"}, map[string]string{"port": "integer"}},
+		`"yes" is not a valid boolean`:  {map[string]string{"debug": "yes"}, map[string]string{"debug": "boolean"}},
+		`date is not a valid type`:      {map[string]string{"since": "2019-01-01"}, map[string]string{"since": "date"}},
+		`"port" is not set`:             {map[string]string{"debug": "true"}, map[string]string{"port": "integer"}},
+	}
+	for expected, tc := range cases {
+		if _, err := configTOML(tc.config, tc.types); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v %v: expected an error containing %s, got %v", tc.config, tc.types, expected, err)
+		}
+	}
+}
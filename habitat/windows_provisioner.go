@@ -1,161 +1,534 @@
 package habitat
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/hashicorp/terraform/communicator"
 	"github.com/hashicorp/terraform/terraform"
 )
 
+// windowsPlatform provisions Windows targets over winrm.
+type windowsPlatform struct {
+	*provisioner
+}
+
+// winInstallURL is the download location of the hab archive for a version, or
+// "latest".
+const winInstallURL = "https://packages.chef.io/files/stable/habitat/%s/hab-x86_64-windows.zip"
+
+// winMinVersion is the oldest Habitat release available from winInstallURL.
+const winMinVersion = "0.90.0"
+
+// installScript installs or updates hab and the Habitat Windows service. It
+// stops on the first error, like the scripts rendered by command.PowerShell.
 const installScript = `
+$ErrorActionPreference = 'Stop'
 [Net.ServicePointManager]::SecurityProtocol = [Net.SecurityProtocolType]::Tls12
-iwr https://api.bintray.com/content/habitat/stable/windows/x86_64/hab-%%24latest-x86_64-windows.zip?bt_package=hab-x86_64-windows -Outfile c:\habitat.zip
-Expand-Archive c:/habitat.zip c:/
+{{ if .Archive -}}
+Move-Item -Force {{ psQuote .Archive }} c:\habitat.zip
+{{ else -}}
+iwr {{ psQuote .URL }} -Outfile c:\habitat.zip
+{{ if .Checksum -}}
+$hash = (Get-FileHash -Algorithm SHA256 c:\habitat.zip).Hash.ToLower()
+if ($hash -ne {{ psQuote .Checksum }}) {
+  Remove-Item c:\habitat.zip
+  Write-Output ("Checksum mismatch for " + {{ psQuote .URL }} + ": expected SHA-256 " + {{ psQuote .Checksum }} + ", got " + $hash)
+  exit 1
+}
+{{ end -}}
+{{ end -}}
+Expand-Archive -Force c:/habitat.zip c:/
+Remove-Item c:\habitat.zip
+# Replace the hab binary of a previous installation
+if (Test-Path c:\habitat) {
+  Remove-Item -Recurse -Force c:\habitat
+}
 mv c:/hab-* c:/habitat
-$env:Path = $env:Path,"C:\habitat" -join ";"
-[System.Environment]::SetEnvironmentVariable('Path', $env:Path, [System.EnvironmentVariableTarget]::Machine)
+if (($env:Path -split ';') -notcontains 'C:\habitat') {
+  $env:Path = $env:Path,"C:\habitat" -join ";"
+  [System.Environment]::SetEnvironmentVariable('Path', $env:Path, [System.EnvironmentVariableTarget]::Machine)
+}
 # Install hab as a Windows service
-hab pkg install core/windows-service
-hab pkg exec core/windows-service install
-New-NetFirewallRule -DisplayName "Habitat TCP" -Direction Inbound -Action Allow -Protocol TCP -LocalPort 9631,9638
-New-NetFirewallRule -DisplayName "Habitat UDP" -Direction Inbound -Action Allow -Protocol UDP -LocalPort 9638
+{{ range .Packages -}}
+hab pkg install {{ psQuote . }}
+if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
+{{ end -}}
+if (-not (Get-Service Habitat -ErrorAction SilentlyContinue)) {
+  hab pkg exec core/windows-service install
+  if ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }
+}
+if (-not (Get-NetFirewallRule -DisplayName "Habitat TCP" -ErrorAction SilentlyContinue)) {
+  New-NetFirewallRule -DisplayName "Habitat TCP" -Direction Inbound -Action Allow -Protocol TCP -LocalPort 9631,9638
+}
+if (-not (Get-NetFirewallRule -DisplayName "Habitat UDP" -ErrorAction SilentlyContinue)) {
+  New-NetFirewallRule -DisplayName "Habitat UDP" -Direction Inbound -Action Allow -Protocol UDP -LocalPort 9638
+}
+`
+
+// ringKeyImportScript pipes an uploaded ring key into hab and removes the key
+// file again, whether or not the import succeeded.
+const ringKeyImportScript = `
+$key = Get-Content -Raw %s
+Remove-Item %s
+$key | hab ring key import
+exit $LASTEXITCODE
 `
 
-func (p *provisioner) winInstallHab(o terraform.UIOutput, comm communicator.Communicator, param ...Params) error {
+// secretFileScript restricts an uploaded file to Administrators and SYSTEM and
+// moves it to its destination.
+const secretFileScript = `
+$ErrorActionPreference = 'Stop'
+$src = %s
+$dst = %s
+$acl = Get-Acl -LiteralPath $src
+$acl.SetAccessRuleProtection($true, $false)
+foreach ($rule in $acl.Access) { $acl.RemoveAccessRule($rule) | Out-Null }
+foreach ($sid in 'S-1-5-32-544', 'S-1-5-18') {
+  $account = New-Object Security.Principal.SecurityIdentifier $sid
+  $acl.AddAccessRule((New-Object Security.AccessControl.FileSystemAccessRule $account, 'FullControl', 'Allow'))
+}
+Set-Acl -LiteralPath $src -AclObject $acl
+New-Item -ItemType Directory -Force -Path (Split-Path $dst) | Out-Null
+Move-Item -Force -LiteralPath $src -Destination $dst
+`
+
+// startScript configures the options passed to hab sup run by the Habitat
+// Windows service and starts it. A running service is restarted if the options
+// changed, or if Habitat was updated.
+const startScript = `
+$configPath = Join-Path $env:SystemDrive "hab\svc\windows-service\HabService.dll.config"
+[xml]$configXml = Get-Content $configPath
+$options = %s
+$restart = %s
+if ($configXml.configuration.appSettings.add[2].value -ne $options) {
+  $configXml.configuration.appSettings.add[2].value = $options
+  $configXml.Save($configPath)
+  Write-Output "Supervisor options changed"
+  $restart = $true
+} else {
+  Write-Output "Supervisor options are unchanged"
+}
+if ($restart -and (Get-Service Habitat).Status -eq 'Running') {
+  Write-Output "Restarting the Habitat service"
+  Restart-Service Habitat
+}
+Start-Service Habitat
+`
+
+func (p *windowsPlatform) run(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, cmd *command) error {
+	if cmd.HasSecrets() {
+		// Run the command from a script that deletes itself, as the encoded
+		// command line is visible to other processes and in the logs.
+		content := "Remove-Item -LiteralPath $PSCommandPath\n" + cmd.PowerShell()
+		return p.runScript(ctx, o, comm, "win_hab_command.ps1", content)
+	}
+	return p.runCommand(ctx, o, comm, powerShellCommand(cmd.PowerShell()), nil)
+}
+
+// habCtl returns a hab command that talks to the control gateway of the
+// supervisor.
+func (p *windowsPlatform) habCtl(args ...string) *command {
+	return newCommand("hab").Args(args...).Option("--remote-sup", p.ListenCtl)
+}
+
+func (p *windowsPlatform) probe(ctx context.Context, comm communicator.Communicator, cmd *command) (string, error) {
+	return p.probeCommand(ctx, comm, powerShellCommand(cmd.PowerShell()))
+}
+
+// installedVersion returns the version of the installed hab binary, or false
+// if hab isn't installed.
+func (p *windowsPlatform) installedVersion(ctx context.Context, comm communicator.Communicator) (string, bool) {
+	out, err := p.probe(ctx, comm, newCommand("hab", "--version").Env("HAB_LICENSE", "accept-no-persist"))
+	if err != nil {
+		return "", false
+	}
+	return parseHabVersion(out)
+}
+
+// serviceStatus returns the state of service, or false if it isn't loaded.
+func (p *windowsPlatform) serviceStatus(ctx context.Context, comm communicator.Communicator, service Service) (serviceStatus, bool) {
+	out, err := p.probe(ctx, comm, p.habCtl("svc", "status", service.Name))
+	if err != nil {
+		return serviceStatus{}, false
+	}
+	status, ok := parseServiceStatus(out)
+	if !ok {
+		return status, false
+	}
 
-	script := path.Join(path.Dir(comm.ScriptPath()), "win_hab_install.ps1")
-	content := fmt.Sprintf(installScript)
+	spec := fmt.Sprintf("C:\\hab\\sup\\%s\\specs\\%s.spec", p.supName(), service.Ident.Name)
+	if out, err := p.probe(ctx, comm, newCommand("Get-Content", "-Raw", "-LiteralPath", spec)); err == nil {
+		status.Spec, _ = parseServiceSpec(out)
+	}
+	return status, true
+}
+
+// remoteChecksum returns the SHA-256 checksum of file on the target, or an
+// empty string if it can't be read.
+func (p *windowsPlatform) remoteChecksum(ctx context.Context, comm communicator.Communicator, file string) string {
+	script := fmt.Sprintf("(Get-FileHash -Algorithm SHA256 -LiteralPath %s -ErrorAction Stop).Hash", psQuote(file))
+	out, err := p.probeCommand(ctx, comm, powerShellCommand(script))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(out))
+}
+
+// runScript uploads a PowerShell script to the target instance and executes it.
+func (p *windowsPlatform) runScript(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, name, content string) error {
+	script := tempPath(comm, name)
 
 	// Upload the script to target instance
 	if err := comm.UploadScript(script, strings.NewReader(content)); err != nil {
-		return fmt.Errorf("Uploading win_hab_install.ps1 failed: %v", err)
+		return fmt.Errorf("Uploading %s failed: %v", name, err)
 	}
 	// Execute Powershell script
-	installCmd := fmt.Sprintf("powershell -NoProfile -ExecutionPolicy Bypass -File %s", script)
-	return p.runCommand(o, comm, installCmd)
+	return p.runCommand(ctx, o, comm, powerShellFile(script), nil)
 }
 
-func (p *provisioner) winStartHab(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
+func (p *windowsPlatform) InstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	if version, ok := p.installedVersion(ctx, comm); ok {
+		if p.habSatisfied(version) {
+			o.Output(fmt.Sprintf("Habitat %s is already installed, skipping installation", version))
+			return nil
+		}
+		o.Output(fmt.Sprintf("Habitat %s is installed, updating to %s", version, p.Version))
+		p.habUpdated = true
+	}
 
-	var content string
-	options := ""
-	if p.PermanentPeer {
-		options += " -I"
+	data := struct {
+		URL      string
+		Checksum string
+		Archive  string
+		Packages []string
+	}{
+		URL:      fmt.Sprintf(winInstallURL, "latest"),
+		Checksum: p.InstallChecksum,
+		Packages: []string{"core/windows-service"},
+	}
+	if p.Version != "" {
+		// Install the matching supervisor first, so the windows service
+		// doesn't pull in the latest one as a dependency.
+		data.URL = fmt.Sprintf(winInstallURL, p.Version)
+		data.Packages = []string{"core/hab-sup/" + p.Version, "core/windows-service"}
+	}
+	if p.InstallScriptURL != "" {
+		data.URL = p.InstallScriptURL
 	}
 
-	if p.ListenGossip != "" {
-		options += fmt.Sprintf(" --listen-gossip %s", p.ListenGossip)
+	if p.Offline != nil {
+		if p.Offline.WindowsService == "" {
+			return errors.New("offline.windows_service is required to install Habitat on windows")
+		}
+
+		data.Archive = tempPath(comm, "habitat.zip")
+		if err := p.uploadLocalFile(ctx, o, comm, p.Offline.HabArchive, data.Archive); err != nil {
+			return err
+		}
+
+		// The launcher and supervisor are installed first, so they satisfy the
+		// dependencies of the windows service.
+		data.Packages = nil
+		for _, hart := range []string{p.Offline.HabLauncher, p.Offline.HabSup, p.Offline.WindowsService} {
+			dst := tempPath(comm, filepath.Base(hart))
+			if err := p.uploadLocalFile(ctx, o, comm, hart, dst); err != nil {
+				return err
+			}
+			data.Packages = append(data.Packages, dst)
+		}
 	}
 
-	if p.ListenHTTP != "" {
-		options += fmt.Sprintf(" --listen-http %s", p.ListenHTTP)
+	var buf bytes.Buffer
+	t := template.Must(template.New("win_hab_install.ps1").
+		Funcs(template.FuncMap{"psQuote": psQuote}).
+		Parse(installScript))
+	if err := t.Execute(&buf, data); err != nil {
+		return fmt.Errorf("Error executing %s template: %s", "win_hab_install.ps1", err)
 	}
 
-	if p.Peer != "" {
-		options += fmt.Sprintf(" --peer %s", p.Peer)
+	if p.Offline != nil {
+		return p.runScript(ctx, o, comm, "win_hab_install.ps1", buf.String())
 	}
+	return p.retry(ctx, o, stepInstallHab, p.Retry, func() error {
+		return p.runScript(ctx, o, comm, "win_hab_install.ps1", buf.String())
+	})
+}
 
-	if p.RingKey != "" {
-		options += fmt.Sprintf(" --ring %s", p.RingKey)
+// installHart uploads a locally supplied .hart file and installs it.
+func (p *windowsPlatform) installHart(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, hart string) error {
+	dst := tempPath(comm, filepath.Base(hart))
+	if err := p.uploadLocalFile(ctx, o, comm, hart, dst); err != nil {
+		return err
 	}
 
-	if p.URL != "" {
-		options += fmt.Sprintf(" --url %s", p.URL)
+	if err := p.run(ctx, o, comm, newCommand("hab", "pkg", "install", dst)); err != nil {
+		return err
+	}
+
+	return p.run(ctx, o, comm, newCommand("Remove-Item", "-LiteralPath", dst))
+}
+
+// tempPath returns the path of name in the directory scripts are uploaded to.
+func tempPath(comm communicator.Communicator, name string) string {
+	return path.Join(path.Dir(comm.ScriptPath()), name)
+}
+
+// writeSecretFile writes content to a file only readable by Administrators and
+// SYSTEM. It is uploaded to the directory scripts are uploaded to, which other
+// users can't read, and restricted before it is moved to dst.
+func (p *windowsPlatform) writeSecretFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content io.Reader) error {
+	src := tempPath(comm, path.Base(strings.Replace(dst, "\\", "/", -1)))
+	err := cancelable(ctx, func() error {
+		return p.UploadFile(ctx, o, comm, src, content)
+	})
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(secretFileScript, psQuote(src), psQuote(dst))
+	if err := p.runCommand(ctx, o, comm, powerShellCommand(script), nil); err != nil {
+		p.run(ctx, o, comm, newCommand("Remove-Item", "-Force", "-LiteralPath", src))
+		return err
 	}
+	return nil
+}
 
-	if p.Channel != "" {
-		options += fmt.Sprintf(" --channel %s", p.Channel)
+func (p *windowsPlatform) UploadRingKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	name, err := keyName(p.RingKeyContent)
+	if err != nil {
+		return fmt.Errorf("Invalid ring_key_content: %v", err)
 	}
+	keyPath := tempPath(comm, fmt.Sprintf("%s.sym.key", name))
 
-	if p.Events != "" {
-		options += fmt.Sprintf(" --events %s", p.Events)
+	// Upload the key content to the target instance
+	if err := p.UploadFile(ctx, o, comm, keyPath, strings.NewReader(p.RingKeyContent)); err != nil {
+		return fmt.Errorf("Uploading ring key failed: %v", err)
 	}
 
-	if p.OverrideName != "" {
-		options += fmt.Sprintf(" --override-name %s", p.OverrideName)
+	content := fmt.Sprintf(ringKeyImportScript, psQuote(keyPath), psQuote(keyPath))
+	return p.runScript(ctx, o, comm, "win_hab_ring_key.ps1", content)
+}
+
+func (p *windowsPlatform) StartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	// The Habitat Windows service runs a single supervisor
+	if len(p.Supervisors) > 0 {
+		return errNotSupported("Running additional supervisors", "windows")
 	}
 
-	if p.Organization != "" {
-		options += fmt.Sprintf(" --org %s", p.Organization)
+	options := append(p.supOptions(), "--no-color")
+	p.SupOptions = joinArgs(options, windowsArg)
+
+	restart := "$false"
+	if p.habUpdated {
+		restart = "$true"
 	}
-	options += fmt.Sprintf(" --no-color")
+	content := fmt.Sprintf(startScript, psQuote(p.SupOptions), restart)
+	return p.runScript(ctx, o, comm, "win_hab_start.ps1", content)
+}
 
-	p.SupOptions = options
-	content += fmt.Sprintf("$svcPath = Join-Path $env:SystemDrive \"hab\\svc\\windows-service\"\n")
-	content += fmt.Sprintf("[xml]$configXml = Get-Content (Join-Path $svcPath HabService.dll.config)\n")
-	content += fmt.Sprintf("$configXml.configuration.appSettings.add[2].value = \"%s\"\n", options)
-	content += fmt.Sprintf("$configXml.Save((Join-Path $svcPath HabService.dll.config))\n")
-	content += fmt.Sprintf("Start-Service Habitat\n")
+// winQueryScript requests a URL and prints the body followed by the status
+// code on a line of its own.
+const winQueryScript = `
+try {
+  $response = Invoke-WebRequest -UseBasicParsing -Uri %s
+  $code = [int]$response.StatusCode
+  $body = $response.Content
+} catch {
+  if (-not $_.Exception.Response) { exit 1 }
+  $code = [int]$_.Exception.Response.StatusCode
+  $body = (New-Object IO.StreamReader($_.Exception.Response.GetResponseStream())).ReadToEnd()
+}
+Write-Output $body
+Write-Output $code
+`
 
-	script := path.Join(path.Dir(comm.ScriptPath()), "win_hab_start.ps1")
+// winPollScript requests a URL until the response has the expected status
+// code, or any if it is 0, giving up after the timeout in seconds.
+const winPollScript = `
+$url = %s
+$expected = %d
+$timeout = %d
+$deadline = (Get-Date).AddSeconds($timeout)
+while ($true) {
+  try {
+    $code = [int](Invoke-WebRequest -UseBasicParsing -Uri $url).StatusCode
+  } catch {
+    $code = 0
+    if ($_.Exception.Response) { $code = [int]$_.Exception.Response.StatusCode }
+  }
+  if ($code -ne 0 -and ($expected -eq 0 -or $code -eq $expected)) { exit 0 }
+  if ((Get-Date) -ge $deadline) {
+    Write-Output "$url did not respond within $timeout seconds"
+    exit 1
+  }
+  Start-Sleep -Seconds 2
+}
+`
 
-	// Upload the script to target instance
-	if err := comm.UploadScript(script, strings.NewReader(content)); err != nil {
-		return fmt.Errorf("Uploading win_hab_start.ps1 failed: %v", err)
+// winLogScript prints the last lines of the most recent log written by the
+// Habitat Windows service.
+const winLogScript = `
+$ErrorActionPreference = 'Stop'
+Get-ChildItem -Path C:\hab\svc\windows-service\logs -Filter *.log |
+  Sort-Object LastWriteTime | Select-Object -Last 1 | Get-Content -Tail %d
+`
+
+func (p *windowsPlatform) QueryHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string) (int, string, error) {
+	url := p.gatewayURL(endpoint)
+	out, err := p.probeCommand(ctx, comm, powerShellCommand(fmt.Sprintf(winQueryScript, psQuote(url))))
+	if err != nil {
+		return 0, "", fmt.Errorf("%s did not respond: %v", url, err)
 	}
-	// Execute Powershell script
-	installCmd := fmt.Sprintf("powershell -NoProfile -ExecutionPolicy Bypass -File %s", script)
-	return p.runCommand(o, comm, installCmd)
+	return parseGatewayResponse(out)
+}
+
+func (p *windowsPlatform) PollHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, endpoint string, code int, timeout time.Duration) error {
+	script := fmt.Sprintf(winPollScript, psQuote(p.gatewayURL(endpoint)), code, int(timeout.Seconds()))
+	return p.runCommand(ctx, o, comm, powerShellCommand(script), nil)
+}
 
+func (p *windowsPlatform) HabLog(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, lines int) (string, error) {
+	return p.probeCommand(ctx, comm, powerShellCommand(fmt.Sprintf(winLogScript, lines)))
 }
 
-func (p *provisioner) winStartHabService(o terraform.UIOutput, comm communicator.Communicator, params ...Params) error {
+// departScript departs the supervisor identified by the member ID file with
+// the given hab command.
+const departScript = `
+$ErrorActionPreference = 'Stop'
+$id = (Get-Content -Raw %s).Trim()
+& %s $id
+exit $LASTEXITCODE
+`
+
+// uninstallScript removes the Habitat Windows service and all Habitat files.
+const uninstallScript = `
+$ErrorActionPreference = 'Stop'
+& hab pkg exec core/windows-service uninstall
+Remove-Item -Recurse -Force C:\hab, C:\habitat
+`
+
+func (p *windowsPlatform) DepartHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	memberID := fmt.Sprintf("C:\\hab\\sup\\%s\\MEMBER_ID", p.supName())
+	depart := joinArgs(p.habCtl("sup", "depart").args, psArg)
+	return p.runCommand(ctx, o, comm, powerShellCommand(fmt.Sprintf(departScript, psQuote(memberID), depart)), nil)
+}
+
+func (p *windowsPlatform) StopHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	if err := p.run(ctx, o, comm, newCommand("Stop-Service", "Habitat")); err != nil {
+		return err
+	}
+	return p.run(ctx, o, comm, newCommand("Set-Service", "Habitat", "-StartupType", "Disabled"))
+}
 
-	var command string
-	var service Service
-	service = params[0].habService
+func (p *windowsPlatform) UninstallHab(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator) error {
+	return p.runCommand(ctx, o, comm, powerShellCommand(uninstallScript), nil)
+}
+
+func (p *windowsPlatform) StartHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+	status, loaded := p.serviceStatus(ctx, comm, service)
+	matches := loaded && status.matches(service, p.Organization)
+	if matches {
+		o.Output(fmt.Sprintf("%s is already loaded as %s in %s", service.Name, status.Ident, status.Group))
+	} else if service.Hart != "" {
+		if err := p.installHart(ctx, o, comm, service.Hart); err != nil {
+			return err
+		}
+	}
 
-	if err := p.winUploadUserTOML(o, comm, service); err != nil {
+	if err := p.uploadUserTOML(ctx, o, comm, service); err != nil {
 		return err
 	}
 
 	// Upload service group key
 	if service.ServiceGroupKey != "" {
-		p.uploadServiceGroupKey(o, comm, service.ServiceGroupKey)
+		if err := p.ImportKey(ctx, o, comm, service.ServiceGroupKey); err != nil {
+			return err
+		}
 	}
 
-	options := ""
-	if service.Topology != "" {
-		options += fmt.Sprintf(" --topology %s", service.Topology)
+	if matches && status.upToDate(service, p.Organization) {
+		return nil
 	}
 
-	if service.Strategy != "" {
-		options += fmt.Sprintf(" --strategy %s", service.Strategy)
+	cmd := p.habCtl("svc", "load", service.Name).
+		Args(service.loadOptions()...).
+		SecretEnv("HAB_AUTH_TOKEN", p.BuilderAuthToken)
+	if matches {
+		// Update the options the service was loaded with
+		o.Output(fmt.Sprintf("Reloading %s to apply its changed options", service.Name))
+		cmd.Flag("--force", true)
+	} else if loaded {
+		// Replace the service loaded with another package or group
+		o.Output(fmt.Sprintf("Reloading %s, currently loaded as %s in %s", service.Name, status.Ident, status.Group))
+		cmd.Flag("--force", true)
 	}
+	return p.retry(ctx, o, stepLoadService, p.retryPolicy(service), func() error {
+		return p.run(ctx, o, comm, cmd)
+	})
+}
 
-	if service.Channel != "" {
-		options += fmt.Sprintf(" --channel %s", service.Channel)
+func (p *windowsPlatform) ApplyHabConfig(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service, group string) error {
+	// The applied configuration is kept to detect changes, only readable by
+	// Administrators and SYSTEM
+	dst := fmt.Sprintf("C:\\hab\\svc\\%s\\config-apply.toml", service.Ident.Name)
+	if service.ApplyConfig == "hash" && p.remoteChecksum(ctx, comm, dst) == sha256Hex([]byte(service.UserTOML)) {
+		o.Output("The configuration of service group " + group + " is unchanged")
+		return nil
 	}
 
-	if service.URL != "" {
-		options += fmt.Sprintf(" --url %s", service.URL)
+	if err := p.writeSecretFile(ctx, o, comm, dst, strings.NewReader(service.UserTOML)); err != nil {
+		return fmt.Errorf("Uploading the configuration of %s failed: %v", service.Name, err)
 	}
 
-	if service.Group != "" {
-		options += fmt.Sprintf(" --group %s", service.Group)
+	incarnation := strconv.FormatUint(configIncarnation(), 10)
+	if err := p.run(ctx, o, comm, p.habCtl("config", "apply", group, incarnation, dst)); err != nil {
+		// Don't let the next run take the configuration as applied
+		p.run(ctx, o, comm, newCommand("Remove-Item", "-LiteralPath", dst))
+		return err
 	}
+	return nil
+}
 
-	for _, bind := range service.Binds {
-		options += fmt.Sprintf(" --bind %s", bind.toBindString())
-	}
-	command = fmt.Sprintf("hab svc load %s %s", service.Name, options)
+func (p *windowsPlatform) UnloadHabService(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+	return p.run(ctx, o, comm, p.habCtl("svc", "unload", service.Name))
+}
 
-	if p.BuilderAuthToken != "" {
-		command = fmt.Sprintf("set HAB_AUTH_TOKEN=%s %s", p.BuilderAuthToken, command)
+func (p *windowsPlatform) UploadFile(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, dst string, content io.Reader) error {
+	return comm.Upload(dst, content)
+}
+
+func (p *windowsPlatform) ImportKey(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, key string) error {
+	name, err := keyName(key)
+	if err != nil {
+		return fmt.Errorf("Invalid service_key: %v", err)
 	}
-	return p.runCommand(o, comm, command)
+	o.Output("Uploading service group key: " + name)
+	destPath := fmt.Sprintf("C:\\hab\\cache\\keys\\%s.box.key", name)
+	return p.UploadFile(ctx, o, comm, destPath, strings.NewReader(key))
 }
 
-func (p *provisioner) winUploadUserTOML(o terraform.UIOutput, comm communicator.Communicator, service Service) error {
+func (p *windowsPlatform) uploadUserTOML(ctx context.Context, o terraform.UIOutput, comm communicator.Communicator, service Service) error {
 	// Create the hab svc directory to lay down the user.toml before loading the service
-	o.Output("Uploading user.toml for service: " + service.Name)
-	svcName := service.getPackageName(service.Name)
+	svcName := service.Ident.Name
 	destDir := fmt.Sprintf("C:\\hab\\user\\%s\\config", svcName)
-	command := fmt.Sprintf("mkdir %s", destDir)
+	if p.remoteChecksum(ctx, comm, path.Join(destDir, "user.toml")) == sha256Hex([]byte(service.UserTOML)) {
+		o.Output("user.toml for service " + service.Name + " is unchanged")
+		return nil
+	}
+
+	o.Output("Uploading user.toml for service: " + service.Name)
+	mkdir := newCommand("New-Item", "-ItemType", "Directory", "-Force", "-Path", destDir)
 
-	if err := p.runCommand(o, comm, command); err != nil {
+	if err := p.run(ctx, o, comm, mkdir); err != nil {
 		return err
 	}
 
@@ -167,8 +540,8 @@ func (p *provisioner) winUploadUserTOML(o terraform.UIOutput, comm communicator.
 
 	command = fmt.Sprintf("move C:\\temp\\%s-user.toml %s\\user.toml", svcName, destDir)
 	o.Output(command)
-	return p.runCommand(o, comm, command)
+	return p.runCommand(ctx, o, comm, command)
 	*/
-	return comm.Upload(path.Join(destDir, "user.toml"), userToml)
+	return p.UploadFile(ctx, o, comm, path.Join(destDir, "user.toml"), userToml)
 
 }
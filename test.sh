@@ -17,3 +17,11 @@ then
 fi
 echo "Successfully ran the unit tests for habitat provisioner"
 
+go test -tags integration ${GOPATH}/src/github.com/chef-partners/terraform-provisioner-habitat/test/integration -v
+if [ $? -ne 0 ];
+then
+    echo "Failure in habitat provisioner integration tests"
+    exit 1
+fi
+echo "Successfully ran the integration tests for habitat provisioner"
+
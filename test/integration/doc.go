@@ -0,0 +1,10 @@
+// Package integration runs the habitat provisioner end-to-end against an
+// in-process SSH server. The tests only build with the integration tag:
+//
+//	go test -tags integration ./test/integration
+//
+// Commands run with sh on the local machine, with the directories the
+// provisioner writes to (/etc, /hab and /tmp) mapped into a temporary root,
+// and hab, sudo, systemctl and curl replaced by stubs that record their
+// invocations. No network access or Habitat installation is needed.
+package integration
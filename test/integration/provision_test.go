@@ -0,0 +1,196 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chef-partners/terraform-provisioner-habitat/habitat"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// stubs are installed on the PATH of the target. They record their arguments
+// in a log named after them.
+var stubs = map[string]string{
+	"sudo": `#!/bin/sh
+echo "$*" >> "$STUB_LOG/sudo.log"
+exec "$@"
+`,
+	"systemctl": `#!/bin/sh
+echo "$*" >> "$STUB_LOG/systemctl.log"
+`,
+	"curl": `#!/bin/sh
+echo "$*" >> "$STUB_LOG/curl.log"
+case "$*" in
+*http_code*) printf '[]\n200' ;;
+*" -o "*) eval "out=\${$#}"; cp "$STUB_DIR/install.sh" "$out" ;;
+esac
+`,
+}
+
+// installScript replaces the Habitat installer, installing the hab stub.
+const installScript = `#!/bin/sh
+echo "$*" >> "$STUB_LOG/install.log"
+mkdir -p "$HAB_BIN" && cp "$STUB_DIR/hab" "$HAB_BIN/hab"
+`
+
+// habStub behaves like hab on a target without any services, and with only
+// the packages installed by hab install.
+const habStub = `#!/bin/sh
+echo "$*" >> "$STUB_LOG/hab.log"
+if [ -n "$HAB_AUTH_TOKEN" ]; then
+	echo "$HAB_AUTH_TOKEN" >> "$STUB_LOG/token.log"
+fi
+case "$1 $2" in
+--version*) echo "hab 0.79.1/20190410220617" ;;
+"pkg path") grep -qx "$3" "$STUB_LOG/installed" 2> /dev/null || exit 1 ;;
+install*) echo "$2" >> "$STUB_LOG/installed" ;;
+"svc status") echo "No services loaded." ;;
+"ring key") cat > /dev/null ;;
+esac
+`
+
+func TestProvision_ssh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	root, err := ioutil.TempDir("", "habitat-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// The hab stub is only on the PATH once the installer has run
+	stubDir := filepath.Join(root, "stubs")
+	fileDir := filepath.Join(root, "files")
+	logDir := filepath.Join(root, "log")
+	habBin := filepath.Join(root, "bin")
+	writeFiles(t, stubDir, stubs)
+	writeFiles(t, fileDir, map[string]string{"hab": habStub, "install.sh": installScript})
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tgt := newTarget(t, root, []string{
+		"PATH=" + stubDir + ":" + habBin + ":" + os.Getenv("PATH"),
+		"STUB_DIR=" + fileDir,
+		"STUB_LOG=" + logDir,
+		"HAB_BIN=" + habBin,
+	})
+	defer tgt.Close()
+
+	state := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{
+				"type":     "ssh",
+				"host":     "127.0.0.1",
+				"port":     tgt.port(),
+				"user":     testUser,
+				"password": testPassword,
+				"agent":    "false",
+				"timeout":  "10s",
+			},
+		},
+	}
+
+	raw, err := config.NewRawConfig(map[string]interface{}{
+		"accept_license":     true,
+		"version":            "0.79.1",
+		"peer":               "10.0.0.1",
+		"builder_auth_token": "s3cret",
+		"ring_key":           "test-ring",
+		"ring_key_content":   "SYM-SEC-1\ntest-ring-20190101000000\n\nc2VjcmV0",
+		"service": []interface{}{
+			map[string]interface{}{
+				"name":      "core/redis",
+				"topology":  "leader",
+				"user_toml": "port = 6380\n",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := terraform.NewResourceConfig(raw)
+
+	o := new(terraform.MockUIOutput)
+	if err := habitat.Provisioner().Apply(o, state, cfg); err != nil {
+		t.Fatalf("provisioning failed: %v\n%s", err, o.OutputMessage)
+	}
+
+	assertContains(t, filepath.Join(logDir, "install.log"), "-v 0.79.1")
+	assertContains(t, filepath.Join(logDir, "hab.log"),
+		"ring key import",
+		"install core/hab-sup/0.79.1",
+		"pkg install core/redis",
+		"svc load core/redis --topology leader",
+	)
+	assertContains(t, filepath.Join(logDir, "token.log"), "s3cret")
+	assertContains(t, filepath.Join(logDir, "systemctl.log"),
+		"daemon-reload",
+		"enable hab-supervisor.service",
+		"restart hab-supervisor.service",
+	)
+	assertContains(t, filepath.Join(root, "etc/systemd/system/hab-supervisor.service"),
+		"ExecStart=/bin/hab sup run --peer 10.0.0.1 --ring test-ring",
+		"EnvironmentFile=/etc/default/hab-supervisor",
+	)
+	assertContains(t, filepath.Join(root, "etc/default/hab-supervisor"), "HAB_AUTH_TOKEN=s3cret")
+	assertContains(t, filepath.Join(root, "hab/svc/redis/user.toml"), "port = 6380")
+
+	// The token is passed on standard input, never on a command line
+	for _, name := range []string{"hab.log", "sudo.log"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(logDir, name)); strings.Contains(string(b), "s3cret") {
+			t.Errorf("%s contains the builder token:\n%s", name, b)
+		}
+	}
+
+	// Provisioning again leaves the unchanged supervisor running
+	os.Remove(filepath.Join(logDir, "systemctl.log"))
+	if err := habitat.Provisioner().Apply(o, state, cfg); err != nil {
+		t.Fatalf("provisioning again failed: %v\n%s", err, o.OutputMessage)
+	}
+	assertContains(t, filepath.Join(logDir, "systemctl.log"), "start hab-supervisor.service")
+	if b, _ := ioutil.ReadFile(filepath.Join(logDir, "systemctl.log")); strings.Contains(string(b), "restart") {
+		t.Errorf("expected the supervisor not to be restarted:\n%s", b)
+	}
+}
+
+// writeFiles writes executable files into dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertContains checks that the file at path contains each of substrings.
+func assertContains(t *testing.T, path string, substrings ...string) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, s := range substrings {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("%s doesn't contain %q:\n%s", path, s, b)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testUser     = "habitat"
+	testPassword = "habitat"
+)
+
+// targetPaths matches the absolute paths mapped into the root of the target.
+var targetPaths = regexp.MustCompile(`(^|[\s'"=(])(/etc|/hab|/tmp)(/|[\s'"]|$)`)
+
+// target is a fake machine behind an in-process SSH server. Commands run with
+// sh, with the paths of the target mapped into root and env added to their
+// environment.
+type target struct {
+	root string
+	home string
+	env  []string
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+}
+
+func newTarget(t *testing.T, root string, env []string) *target {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := filepath.Join(root, "home", testUser)
+	for _, dir := range []string{home, filepath.Join(root, "etc"), filepath.Join(root, "hab"), filepath.Join(root, "tmp")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tgt := &target{root: root, home: home, env: env, listener: listener, config: config}
+	go tgt.serve()
+	return tgt
+}
+
+// port returns the port the SSH server listens on.
+func (tgt *target) port() string {
+	return strconv.Itoa(tgt.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (tgt *target) Close() error {
+	return tgt.listener.Close()
+}
+
+// path maps the absolute paths of the target in command into its root.
+func (tgt *target) path(command string) string {
+	return targetPaths.ReplaceAllString(command, "${1}"+tgt.root+"${2}${3}")
+}
+
+func (tgt *target) serve() {
+	for {
+		conn, err := tgt.listener.Accept()
+		if err != nil {
+			return
+		}
+		go tgt.handleConn(conn)
+	}
+}
+
+func (tgt *target) handleConn(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, tgt.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go tgt.handleSession(channel, requests)
+	}
+}
+
+func (tgt *target) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			status := tgt.exec(channel, strings.TrimSpace(payload.Command))
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		case "pty-req", "env":
+			// Output is kept unbuffered, so the pty isn't needed
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// exec runs a command of the provisioner and returns its exit status.
+func (tgt *target) exec(channel ssh.Channel, command string) uint32 {
+	if strings.HasPrefix(command, "scp -vt ") {
+		dir := tgt.path(strings.TrimPrefix(command, "scp -vt "))
+		if err := scpSink(channel, dir); err != nil {
+			fmt.Fprintf(channel.Stderr(), "scp: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	cmd := exec.Command("sh", "-c", tgt.path(command))
+	cmd.Dir = tgt.home
+	cmd.Env = append(os.Environ(), tgt.env...)
+	cmd.Stdin = channel
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return uint32(status.ExitStatus())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(channel.Stderr(), "%v\n", err)
+		return 127
+	}
+	return 0
+}
+
+// scpSink receives the files uploaded with the scp protocol into dir.
+func scpSink(channel ssh.Channel, dir string) error {
+	r := bufio.NewReader(channel)
+	ack := func() error {
+		_, err := channel.Write([]byte{0})
+		return err
+	}
+	if err := ack(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch line[0] {
+		case 'C':
+			var mode, name string
+			var size int64
+			if _, err := fmt.Sscanf(line, "C%s %d %s\n", &mode, &size, &name); err != nil {
+				return fmt.Errorf("invalid file header %q", line)
+			}
+			if err := ack(); err != nil {
+				return err
+			}
+
+			content := make([]byte, size+1)
+			if _, err := io.ReadFull(r, content); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(name)), content[:size], 0644); err != nil {
+				return err
+			}
+		case 'D', 'E', 'T':
+			// Only single files are uploaded by the provisioner
+		default:
+			return fmt.Errorf("unexpected scp message %q", line)
+		}
+		if err := ack(); err != nil {
+			return err
+		}
+	}
+}